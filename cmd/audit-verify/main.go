@@ -0,0 +1,82 @@
+/*
+Audit-verify checks the hash chain of the audit log of a WASAText database.
+
+Usage:
+
+	audit-verify [database file]
+
+The database file defaults to WASATEXT_DB_FILENAME, or ./wasatext.db. The command
+prints the number of entries and the hash of the last one, and exits with status 1 if
+the chain is broken. Entries removed from the end of the log leave a valid chain: note
+the last hash and compare it on the next run to detect them.
+*/
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+
+	if err := run(logger); err != nil {
+		logger.WithError(err).Error("audit log verification failed")
+		os.Exit(1)
+	}
+}
+
+func run(logger *logrus.Logger) error {
+	filename := os.Getenv("WASATEXT_DB_FILENAME")
+	if len(os.Args) > 1 {
+		filename = os.Args[1]
+	}
+	if filename == "" {
+		filename = "./wasatext.db"
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+
+	dbconn, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer func() { _ = dbconn.Close() }()
+
+	db, err := database.New(dbconn)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	count, last, err := audit.Verify(db)
+	var broken *audit.BrokenChainError
+	if errors.As(err, &broken) {
+		logger.WithField("verified", count).Error(broken.Error())
+		return errors.New("the audit log has been tampered with")
+	}
+	if err != nil {
+		return err
+	}
+
+	if last == nil {
+		logger.Info("The audit log is empty")
+		return nil
+	}
+	logger.WithFields(logrus.Fields{
+		"entries":  count,
+		"lastSeq":  last.Seq,
+		"lastHash": last.Hash,
+		"lastTime": last.CreatedAt,
+	}).Info("Audit log verified")
+	return nil
+}
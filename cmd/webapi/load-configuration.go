@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/sapienzaapps/wasatext/service/ratelimit"
 )
 
 // WebAPIConfiguration is the configuration for the web API server
@@ -16,7 +21,37 @@ type WebAPIConfiguration struct {
 	DB struct {
 		Filename string
 	}
-	Debug bool
+	Upload struct {
+		MaxPhotoSize int64
+		MaxFileSize  int64
+		MaxAudioSize int64
+		MaxAlbumSize int64
+	}
+	Quota struct {
+		User         int64
+		Conversation int64
+	}
+	RateLimit struct {
+		Login           ratelimit.Limit
+		Messaging       ratelimit.Limit
+		API             ratelimit.Limit
+		IncomingWebhook ratelimit.Limit
+		TrustedProxies  int
+	}
+	OIDC struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Scopes       []string
+		PostLoginURL string
+	}
+	Webhook struct {
+		AllowHTTP    bool
+		AllowPrivate bool
+	}
+	Admins []string
+	Debug  bool
 }
 
 func loadConfiguration() (WebAPIConfiguration, error) {
@@ -33,6 +68,84 @@ func loadConfiguration() (WebAPIConfiguration, error) {
 		cfg.DB.Filename = "./wasatext.db"
 	}
 
+	// Upload limits in bytes, zero keeps the API defaults
+	var err error
+	cfg.Upload.MaxPhotoSize, err = envInt64("WASATEXT_UPLOAD_MAX_PHOTO")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Upload.MaxFileSize, err = envInt64("WASATEXT_UPLOAD_MAX_FILE")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Upload.MaxAudioSize, err = envInt64("WASATEXT_UPLOAD_MAX_AUDIO")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Upload.MaxAlbumSize, err = envInt64("WASATEXT_UPLOAD_MAX_ALBUM")
+	if err != nil {
+		return cfg, err
+	}
+
+	// Storage quotas in bytes, zero means unlimited
+	cfg.Quota.User, err = envInt64("WASATEXT_QUOTA_USER")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Quota.Conversation, err = envInt64("WASATEXT_QUOTA_CONVERSATION")
+	if err != nil {
+		return cfg, err
+	}
+
+	// Rate limits as "<requests>/<duration>", unset keeps the API defaults
+	cfg.RateLimit.Login, err = envLimit("WASATEXT_RATELIMIT_LOGIN")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RateLimit.Messaging, err = envLimit("WASATEXT_RATELIMIT_MESSAGING")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RateLimit.API, err = envLimit("WASATEXT_RATELIMIT_API")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.RateLimit.IncomingWebhook, err = envLimit("WASATEXT_RATELIMIT_INCOMING_WEBHOOK")
+	if err != nil {
+		return cfg, err
+	}
+	// Number of reverse proxies in front of the server, "true" for one
+	switch os.Getenv("WASATEXT_RATELIMIT_TRUST_PROXY") {
+	case "true":
+		cfg.RateLimit.TrustedProxies = 1
+	case "false":
+	default:
+		proxies, err := envInt64("WASATEXT_RATELIMIT_TRUST_PROXY")
+		if err != nil {
+			return cfg, err
+		}
+		cfg.RateLimit.TrustedProxies = int(proxies)
+	}
+
+	// Single sign-on, enabled by setting the issuer
+	cfg.OIDC.Issuer = os.Getenv("WASATEXT_OIDC_ISSUER")
+	cfg.OIDC.ClientID = os.Getenv("WASATEXT_OIDC_CLIENT_ID")
+	cfg.OIDC.ClientSecret = os.Getenv("WASATEXT_OIDC_CLIENT_SECRET")
+	cfg.OIDC.RedirectURL = os.Getenv("WASATEXT_OIDC_REDIRECT_URL")
+	cfg.OIDC.Scopes = strings.Fields(os.Getenv("WASATEXT_OIDC_SCOPES"))
+	if len(cfg.OIDC.Scopes) == 0 {
+		cfg.OIDC.Scopes = []string{"profile", "email"}
+	}
+	cfg.OIDC.PostLoginURL = os.Getenv("WASATEXT_OIDC_POST_LOGIN_URL")
+
+	cfg.Webhook.AllowHTTP = os.Getenv("WASATEXT_WEBHOOK_ALLOW_HTTP") == "true"
+	cfg.Webhook.AllowPrivate = os.Getenv("WASATEXT_WEBHOOK_ALLOW_PRIVATE") == "true"
+
+	// Usernames made server admins at startup
+	cfg.Admins = strings.FieldsFunc(os.Getenv("WASATEXT_ADMINS"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
 	// Hardcoded timeouts for simplicity
 	cfg.Web.ReadTimeout = 5 * time.Second
 	cfg.Web.WriteTimeout = 5 * time.Second
@@ -46,3 +159,29 @@ func loadConfiguration() (WebAPIConfiguration, error) {
 
 	return cfg, nil
 }
+
+// envInt64 reads a non-negative integer from the environment, returning 0 when unset
+func envInt64(name string) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// envLimit reads a rate limit from the environment, returning the zero Limit when unset
+func envLimit(name string) (ratelimit.Limit, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return ratelimit.Limit{}, nil
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		return ratelimit.Limit{}, fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	return limit, nil
+}
@@ -24,7 +24,10 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sapienzaapps/wasatext/service/api"
+	"github.com/sapienzaapps/wasatext/service/audit"
 	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/oidc"
+	"github.com/sapienzaapps/wasatext/service/ratelimit"
 	"github.com/sirupsen/logrus"
 )
 
@@ -73,6 +76,13 @@ func run() error {
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	auditLog := audit.New(db)
+
+	if err := grantAdmins(db, auditLog, cfg.Admins, logger); err != nil {
+		logger.WithError(err).Error("error granting admin roles")
+		return fmt.Errorf("granting admin roles: %w", err)
+	}
+
 	// Start API server
 	logger.Info("initializing API server")
 
@@ -83,10 +93,41 @@ func run() error {
 	// Make a channel to listen for server errors
 	serverErrors := make(chan error, 1)
 
+	var oidcConfig *oidc.Config
+	if cfg.OIDC.Issuer != "" {
+		oidcConfig = &oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		}
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
 		Logger:   logger,
 		Database: db,
+		AuditLog: auditLog,
+		UploadLimits: map[string]int64{
+			api.MessageTypePhoto: cfg.Upload.MaxPhotoSize,
+			api.MessageTypeFile:  cfg.Upload.MaxFileSize,
+			api.MessageTypeAudio: cfg.Upload.MaxAudioSize,
+			api.MessageTypeAlbum: cfg.Upload.MaxAlbumSize,
+		},
+		UserQuota:         cfg.Quota.User,
+		ConversationQuota: cfg.Quota.Conversation,
+		RateLimits: map[string]ratelimit.Limit{
+			api.RateLimitLogin:           cfg.RateLimit.Login,
+			api.RateLimitMessaging:       cfg.RateLimit.Messaging,
+			api.RateLimitAPI:             cfg.RateLimit.API,
+			api.RateLimitIncomingWebhook: cfg.RateLimit.IncomingWebhook,
+		},
+		TrustedProxies:      cfg.RateLimit.TrustedProxies,
+		OIDC:                oidcConfig,
+		OIDCPostLoginURL:    cfg.OIDC.PostLoginURL,
+		WebhookAllowHTTP:    cfg.Webhook.AllowHTTP,
+		WebhookAllowPrivate: cfg.Webhook.AllowPrivate,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -152,3 +193,60 @@ func run() error {
 
 	return nil
 }
+
+// grantAdmins makes the users with the given usernames server admins. Admins can then
+// grant the role to others through the API. Admins need a password, since user IDs are
+// public: accounts without one are skipped, and earlier admins without one are demoted.
+func grantAdmins(db database.AppDatabase, auditLog *audit.Log, usernames []string, logger logrus.FieldLogger) error {
+	demoted, err := db.RevokePasswordlessAdmins()
+	if err != nil {
+		return err
+	}
+	for _, user := range demoted {
+		logger.Warnf("admin %q has no password, role revoked", user.Username)
+		err = auditLog.Record(audit.Entry{
+			Action:   audit.ActionAdminRevoke,
+			TargetID: user.ID,
+			Details:  audit.Details{"username": user.Username, "reason": "no_password"},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, username := range usernames {
+		user, err := db.GetUserByUsername(username)
+		if err != nil {
+			return err
+		}
+		if user == nil || user.Bot {
+			logger.Warnf("admin %q is not a user, log in first and restart", username)
+			continue
+		}
+		if user.Admin {
+			continue
+		}
+		password, err := db.GetPassword(user.ID)
+		if err != nil {
+			return err
+		}
+		if password == nil {
+			// Whoever registered the name first could otherwise claim the role
+			logger.Warnf("admin %q has no password, set one and restart", username)
+			continue
+		}
+		if err := db.SetUserAdmin(user.ID, true); err != nil {
+			return err
+		}
+		logger.Infof("granted admin role to %q (%s)", user.Username, user.ID)
+		err = auditLog.Record(audit.Entry{
+			Action:   audit.ActionAdminGrant,
+			TargetID: user.ID,
+			Details:  audit.Details{"username": user.Username, "source": "WASATEXT_ADMINS"},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type exportProfile struct {
+	ID         string          `json:"id"`
+	Username   string          `json:"username"`
+	Photo      string          `json:"photo,omitempty"` // path in the archive
+	Admin      bool            `json:"admin"`
+	Password   bool            `json:"password"`
+	TwoFactor  bool            `json:"twoFactor"`
+	Privacy    privacyResponse `json:"privacy"`
+	Blocked    []userResponse  `json:"blocked"`
+	Bots       []userResponse  `json:"bots"`
+	ExportedAt string          `json:"exportedAt"`
+}
+
+type exportConversation struct {
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Name    string         `json:"name,omitempty"`
+	Request bool           `json:"request"` // a message request not accepted yet
+	Members []userResponse `json:"members"`
+}
+
+type exportMessage struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversationId"`
+	ReplyToID      string `json:"replyToId,omitempty"`
+	*messageSnapshot
+	Media []string `json:"media,omitempty"` // paths in the archive
+}
+
+type exportReaction struct {
+	MessageID string `json:"messageId"`
+	Comment   string `json:"comment"`
+}
+
+// deleteMyAccount deletes the user's account with their bots. Their messages stay in
+// shared conversations under a placeholder name. Users with a password confirm with
+// it, and with a code when they use two-factor authentication.
+func (rt *_router) deleteMyAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// The body is optional for users without a password
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	password, err := rt.db.GetPassword(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if password != nil {
+		if !rt.checkPassword(w, userID, password, req.Password, http.StatusForbidden) {
+			return
+		}
+		secondFactor, err := rt.db.GetTOTP(userID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if secondFactor != nil && secondFactor.Enabled && !rt.checkSecondFactor(w, userID, secondFactor, req.Code, http.StatusForbidden) {
+			return
+		}
+	}
+
+	// Groups are told the user left once the account is gone
+	conversations, err := rt.db.GetUserConversations(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting conversations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	bots, err := rt.db.GetBots(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bots")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := rt.db.DeleteUser(userID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range conversations {
+		if c.Type == ConversationTypeGroup {
+			rt.publishMember(c.ID, webhook.EventMemberRemoved, userID, userID)
+		}
+	}
+	rt.audit(r, userID, audit.ActionAccountDeleted, userID, audit.Details{
+		"username": user.Username,
+		"bots":     strconv.Itoa(len(bots)),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportMyData streams a ZIP archive of the user's profile, conversations, the messages
+// they sent with their media, and their reactions
+func (rt *_router) exportMyData(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Everything but the messages is loaded before the response starts, so that
+	// failures can still be reported
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	profile, err := rt.newExportProfile(user)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error exporting profile")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	conversations, err := rt.newExportConversations(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error exporting conversations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	comments, err := rt.db.GetUserComments(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error exporting reactions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	reactions := make([]exportReaction, len(comments))
+	for i, c := range comments {
+		reactions[i] = exportReaction{MessageID: c.MessageID, Comment: c.Comment}
+	}
+	messageIDs, err := rt.db.GetUserMessageIDs(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error exporting messages")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rt.audit(r, userID, audit.ActionDataExported, userID, nil)
+
+	// Archives with much media take longer than other responses
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		rt.baseLogger.WithError(err).Debug("cannot extend the write deadline")
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="wasatext-`+user.Username+`.zip"`)
+
+	// Past this point errors can only cut the archive short
+	archive := zip.NewWriter(w)
+	if err := rt.writeExport(archive, user, profile, conversations, reactions, messageIDs); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing export")
+		return
+	}
+	if err := archive.Close(); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing export")
+	}
+}
+
+// newExportProfile collects the account and settings of a user
+func (rt *_router) newExportProfile(user *database.User) (*exportProfile, error) {
+	profile := &exportProfile{
+		ID:         user.ID,
+		Username:   user.Username,
+		Admin:      user.Admin,
+		Blocked:    []userResponse{},
+		Bots:       []userResponse{},
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(user.Photo) > 0 {
+		profile.Photo = "profile-photo" + mediaExtension(http.DetectContentType(user.Photo))
+	}
+
+	password, err := rt.db.GetPassword(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	profile.Password = password != nil
+
+	secondFactor, err := rt.db.GetTOTP(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	profile.TwoFactor = secondFactor != nil && secondFactor.Enabled
+
+	settings, err := rt.db.GetPrivacySettings(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	profile.Privacy = privacyResponse{
+		ForwardAttribution: settings.ForwardAttribution,
+		PhotoVisibility:    settings.PhotoVisibility,
+		GroupAdd:           settings.GroupAdd,
+		ReadReceipts:       settings.ReadReceipts,
+		Searchable:         settings.Searchable,
+	}
+
+	blocked, err := rt.db.GetBlockedUsers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blocked {
+		profile.Blocked = append(profile.Blocked, userResponse{ID: b.ID, Username: b.Username, Bot: b.Bot})
+	}
+
+	bots, err := rt.db.GetBots(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bots {
+		profile.Bots = append(profile.Bots, userResponse{ID: b.ID, Username: b.Username, Bot: true})
+	}
+	return profile, nil
+}
+
+// newExportConversations lists the conversations and message requests of a user with their members
+func (rt *_router) newExportConversations(userID string) ([]exportConversation, error) {
+	previews, err := rt.db.GetUserConversations(userID)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := rt.db.GetMessageRequests(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := []exportConversation{}
+	for i, p := range append(previews, requests...) {
+		conversation := exportConversation{
+			ID:      p.ID,
+			Type:    p.Type,
+			Name:    p.Name,
+			Request: i >= len(previews),
+			Members: []userResponse{},
+		}
+		members, err := rt.db.GetGroupMembers(p.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			conversation.Members = append(conversation.Members, userResponse{ID: m.ID, Username: m.Username, Bot: m.Bot})
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
+
+// writeExport writes the files of an export. Messages are loaded one at a time, so
+// that their media is never all in memory.
+func (rt *_router) writeExport(archive *zip.Writer, user *database.User, profile *exportProfile, conversations []exportConversation,
+	reactions []exportReaction, messageIDs []string) error {
+	if err := writeExportJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+	if profile.Photo != "" {
+		if err := writeExportFile(archive, profile.Photo, user.Photo); err != nil {
+			return err
+		}
+	}
+	if err := writeExportJSON(archive, "conversations.json", conversations); err != nil {
+		return err
+	}
+	if err := writeExportJSON(archive, "reactions.json", reactions); err != nil {
+		return err
+	}
+
+	messages := []exportMessage{}
+	for _, id := range messageIDs {
+		msg, err := rt.db.GetMessage(id)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			// Deleted during the export
+			continue
+		}
+
+		snapshot, media := newMessageSnapshot(msg)
+		exported := exportMessage{
+			ID:              msg.ID,
+			ConversationID:  msg.ConversationID,
+			ReplyToID:       msg.ReplyToID,
+			messageSnapshot: snapshot,
+		}
+		for i, m := range media {
+			name := "media/" + msg.ID
+			switch {
+			case msg.Type == MessageTypeFile || msg.Type == MessageTypeAudio:
+				name += "-" + exportFileName(msg.FileName)
+			case len(media) > 1:
+				name += "-" + strconv.Itoa(i+1) + mediaExtension(m.MimeType)
+			default:
+				name += mediaExtension(m.MimeType)
+			}
+			if err := writeExportFile(archive, name, m.Data); err != nil {
+				return err
+			}
+			exported.Media = append(exported.Media, name)
+		}
+		messages = append(messages, exported)
+	}
+	return writeExportJSON(archive, "messages.json", messages)
+}
+
+func writeExportJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func writeExportFile(archive *zip.Writer, name string, data []byte) error {
+	// Media is compressed already
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// exportFileName makes the name of an uploaded file safe to use in an archive
+func exportFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// mediaExtension returns the file extension of the image types photos are stored as
+func mediaExtension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
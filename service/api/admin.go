@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+type adminUserResponse struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Bot         bool   `json:"bot"`
+	OwnerID     string `json:"ownerId,omitempty"`
+	Admin       bool   `json:"admin"`
+	Suspended   bool   `json:"suspended"`
+	SuspendedAt string `json:"suspendedAt,omitempty"`
+}
+
+type adminUsersResponse struct {
+	Users      []adminUserResponse `json:"users"`
+	NextOffset int                 `json:"nextOffset,omitempty"`
+}
+
+type serverStatsResponse struct {
+	Users struct {
+		People    int64 `json:"people"`
+		Bots      int64 `json:"bots"`
+		Admins    int64 `json:"admins"`
+		Suspended int64 `json:"suspended"`
+	} `json:"users"`
+	Conversations struct {
+		Groups  int64 `json:"groups"`
+		Private int64 `json:"private"`
+	} `json:"conversations"`
+	Messages int64 `json:"messages"`
+	Storage  struct {
+		Media    int64 `json:"media"`
+		Database int64 `json:"database"`
+	} `json:"storage"`
+}
+
+// listUsers lists every account, or those whose username contains q, including bots
+// and users who chose not to appear in searches
+func (rt *_router) listUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	// Fetch one extra user to know whether there is a next page
+	users, err := rt.db.ListUsers(r.URL.Query().Get("q"), adminUsersPageSize+1, offset)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error listing users")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := adminUsersResponse{Users: []adminUserResponse{}}
+	if len(users) > adminUsersPageSize {
+		users = users[:adminUsersPageSize]
+		response.NextOffset = offset + adminUsersPageSize
+	}
+	for i := range users {
+		response.Users = append(response.Users, newAdminUserResponse(&users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// suspendUser suspends an account: its sessions end and it can no longer log in or
+// call the API, nor can its bots
+func (rt *_router) suspendUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	user := rt.getAdminTarget(w, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+	if user.Admin {
+		http.Error(w, "Admins can't be suspended, revoke their admin role first", http.StatusConflict)
+		return
+	}
+
+	if err := rt.db.SuspendUser(user.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error suspending user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminSuspend, user.ID, audit.Details{"username": user.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsuspendUser lifts the suspension of an account
+func (rt *_router) unsuspendUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	user := rt.getAdminTarget(w, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+
+	if err := rt.db.UnsuspendUser(user.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error unsuspending user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminUnsuspend, user.ID, audit.Details{"username": user.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renameUser replaces the username of any account, e.g. an abusive one
+func (rt *_router) renameUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	user := rt.getAdminTarget(w, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+
+	var req setUsernameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !rt.changeUsername(w, user.ID, req.Username) {
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminRename, user.ID, audit.Details{"from": user.Username, "to": req.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// grantAdmin makes a user a server admin
+func (rt *_router) grantAdmin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	user := rt.getAdminTarget(w, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+	if user.Bot {
+		http.Error(w, "Bots can't be admins", http.StatusBadRequest)
+		return
+	}
+	if user.SuspendedAt != "" {
+		http.Error(w, "Suspended users can't be admins", http.StatusConflict)
+		return
+	}
+
+	// Without a password, the user ID would be enough to act as the admin
+	password, err := rt.db.GetPassword(user.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if password == nil {
+		http.Error(w, "Users must set a password before becoming admins", http.StatusConflict)
+		return
+	}
+
+	if err := rt.db.SetUserAdmin(user.ID, true); err != nil {
+		rt.baseLogger.WithError(err).Error("error granting admin role")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminGrant, user.ID, audit.Details{"username": user.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAdmin takes the server admin role away from a user. Admins can't revoke their
+// own role, so that the server always keeps one.
+func (rt *_router) revokeAdmin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	user := rt.getAdminTarget(w, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+	if user.ID == ctx.UserID {
+		http.Error(w, "You can't revoke your own admin role", http.StatusConflict)
+		return
+	}
+
+	if err := rt.db.SetUserAdmin(user.ID, false); err != nil {
+		rt.baseLogger.WithError(err).Error("error revoking admin role")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminRevoke, user.ID, audit.Details{"username": user.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteGroup deletes a group with its messages, members and webhooks
+func (rt *_router) deleteGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	conv, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting group")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if conv == nil || conv.Type != ConversationTypeGroup {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	if err := rt.db.DeleteConversation(groupID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting group")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminGroupDelete, groupID, audit.Details{"name": conv.GroupName})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getServerStats counts the accounts, conversations and messages of the server and
+// the storage they use
+func (rt *_router) getServerStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	stats, err := rt.db.GetServerStats()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting server stats")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var response serverStatsResponse
+	response.Users.People = stats.Users
+	response.Users.Bots = stats.Bots
+	response.Users.Admins = stats.Admins
+	response.Users.Suspended = stats.SuspendedUsers
+	response.Conversations.Groups = stats.Groups
+	response.Conversations.Private = stats.PrivateChats
+	response.Messages = stats.Messages
+	response.Storage.Media = stats.MediaBytes
+	response.Storage.Database = stats.DatabaseBytes
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getAdminTarget loads the user an admin action applies to, or writes an error and returns nil
+func (rt *_router) getAdminTarget(w http.ResponseWriter, userID string) *database.User {
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil
+	}
+	return user
+}
+
+// newAdminUserResponse converts a user to the representation shown to admins
+func newAdminUserResponse(user *database.User) adminUserResponse {
+	return adminUserResponse{
+		ID:          user.ID,
+		Username:    user.Username,
+		Bot:         user.Bot,
+		OwnerID:     user.OwnerID,
+		Admin:       user.Admin,
+		Suspended:   user.SuspendedAt != "",
+		SuspendedAt: user.SuspendedAt,
+	}
+}
@@ -4,30 +4,85 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/database"
 )
 
 // Handler returns an instance of httprouter.Router that handles APIs
 func (rt *_router) Handler() http.Handler {
 	// Login - no auth required
-	rt.router.POST("/session", rt.doLogin)
+	rt.router.POST("/session", rt.limitByIP(RateLimitLogin, rt.doLogin))
+	rt.router.DELETE("/session", rt.wrap(rt.doLogout))
+	rt.router.GET("/session/oidc", rt.limitByIP(RateLimitLogin, rt.startOIDCLogin))
+	rt.router.GET("/session/oidc/callback", rt.limitByIP(RateLimitLogin, rt.oidcCallback))
 
 	// User routes
 	rt.router.PUT("/users/:userId/username", rt.wrap(rt.setMyUserName))
 	rt.router.PUT("/users/:userId/photo", rt.wrap(rt.setMyPhoto))
 	rt.router.GET("/users", rt.wrap(rt.searchUsers))
+	rt.router.DELETE("/users/:userId", rt.wrap(rt.deleteMyAccount))
+	rt.router.GET("/users/:userId/export", rt.wrap(rt.exportMyData))
+	rt.router.GET("/users/:userId/storage", rt.wrap(rt.getMyStorage))
+	rt.router.PUT("/users/:userId/password", rt.wrap(rt.setPassword))
+	rt.router.DELETE("/users/:userId/sessions", rt.wrap(rt.endOtherSessions))
+	rt.router.GET("/users/:userId/totp", rt.wrap(rt.getTOTP))
+	rt.router.POST("/users/:userId/totp", rt.wrap(rt.enrollTOTP))
+	rt.router.GET("/users/:userId/totp/qr", rt.wrap(rt.getTOTPQRCode))
+	rt.router.POST("/users/:userId/totp/verify", rt.wrap(rt.confirmTOTP))
+	rt.router.POST("/users/:userId/totp/disable", rt.wrap(rt.disableTOTP))
+	rt.router.GET("/users/:userId/privacy", rt.wrap(rt.getMyPrivacy))
+	rt.router.PUT("/users/:userId/privacy", rt.wrap(rt.setMyPrivacy))
+	rt.router.GET("/users/:userId/bots", rt.wrap(rt.getMyBots))
+	rt.router.POST("/users/:userId/bots", rt.wrap(rt.createBot))
+	rt.router.GET("/bots/:botId/keys", rt.wrap(rt.getAPIKeys))
+	rt.router.POST("/bots/:botId/keys", rt.wrap(rt.createAPIKey))
+	rt.router.DELETE("/bots/:botId/keys/:keyId", rt.wrap(rt.revokeAPIKey))
+	rt.router.GET("/bots/:botId/commands", rt.wrap(rt.getBotCommands))
+	rt.router.PUT("/bots/:botId/commands", rt.wrap(rt.setBotCommands))
+	rt.router.GET("/bots/:botId/endpoint", rt.wrap(rt.getBotEndpoint))
+	rt.router.PUT("/bots/:botId/endpoint", rt.wrap(rt.setBotEndpoint))
+	rt.router.DELETE("/bots/:botId/endpoint", rt.wrap(rt.deleteBotEndpoint))
+	rt.router.POST("/users/:userId/reports", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.reportUser)))
+	rt.router.GET("/users/:userId/blocks", rt.wrap(rt.getMyBlocks))
+	rt.router.PUT("/users/:userId/blocks/:targetId", rt.wrap(rt.blockUser))
+	rt.router.DELETE("/users/:userId/blocks/:targetId", rt.wrap(rt.unblockUser))
+	rt.router.GET("/users/:userId/message-requests", rt.wrap(rt.getMessageRequests))
+	rt.router.POST("/users/:userId/message-requests/:conversationId/accept", rt.wrap(rt.acceptMessageRequest))
+	rt.router.POST("/users/:userId/message-requests/:conversationId/decline", rt.wrap(rt.declineMessageRequest))
+	rt.router.POST("/users/:userId/message-requests/:conversationId/block", rt.wrap(rt.blockMessageRequest))
 
 	// Conversation routes
-	rt.router.GET("/users/:userId/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.POST("/conversations", rt.wrap(rt.startConversation))
-	rt.router.GET("/conversations/:conversationId", rt.wrap(rt.getConversation))
+	rt.router.GET("/users/:userId/conversations", rt.wrapKey(database.ScopeConversationsRead, rt.getMyConversations))
+	rt.router.POST("/conversations", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.startConversation)))
+	rt.router.GET("/conversations/:conversationId", rt.wrapKey(database.ScopeConversationsRead, rt.getConversation))
+	rt.router.GET("/conversations/:conversationId/media", rt.wrap(rt.getConversationMedia))
+	rt.router.GET("/conversations/:conversationId/commands", rt.wrap(rt.getConversationCommands))
 
 	// Message routes
-	rt.router.POST("/conversations/:conversationId/messages", rt.wrap(rt.sendMessage))
-	rt.router.POST("/conversations/:conversationId/messages/forward", rt.wrap(rt.forwardMessage))
+	rt.router.POST("/conversations/:conversationId/messages", rt.wrapKey(database.ScopeMessagesSend, rt.limitByUser(RateLimitMessaging, rt.sendMessage)))
+	rt.router.POST("/conversations/:conversationId/messages/forward", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.forwardMessage)))
+	rt.router.POST("/forwards", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.forwardMessages)))
 	rt.router.DELETE("/messages/:messageId", rt.wrap(rt.deleteMessage))
+	rt.router.POST("/messages/:messageId/buttons", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.pressButton)))
+	rt.router.POST("/messages/:messageId/reports", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.reportMessage)))
+	rt.router.GET("/messages/:messageId/photo", rt.wrap(rt.getMessagePhoto))
+	rt.router.GET("/messages/:messageId/thumbnail", rt.wrap(rt.getMessageThumbnail))
+	rt.router.GET("/messages/:messageId/file", rt.wrap(rt.getMessageFile))
+	rt.router.GET("/messages/:messageId/audio", rt.wrap(rt.getMessageAudio))
+	rt.router.GET("/messages/:messageId/media/:position", rt.wrap(rt.getMessageMedia))
+	rt.router.GET("/messages/:messageId/media/:position/thumbnail", rt.wrap(rt.getMessageMediaThumbnail))
+
+	// Poll routes
+	rt.router.PUT("/messages/:messageId/votes", rt.wrap(rt.votePoll))
+	rt.router.DELETE("/messages/:messageId/votes", rt.wrap(rt.unvotePoll))
+	rt.router.POST("/messages/:messageId/poll/close", rt.wrap(rt.closePoll))
+
+	// Location routes
+	rt.router.GET("/messages/:messageId/location", rt.wrap(rt.getLocation))
+	rt.router.PUT("/messages/:messageId/location", rt.wrap(rt.updateLiveLocation))
+	rt.router.POST("/messages/:messageId/location/stop", rt.wrap(rt.stopLiveLocation))
 
 	// Reaction routes
-	rt.router.PUT("/messages/:messageId/comment", rt.wrap(rt.commentMessage))
+	rt.router.PUT("/messages/:messageId/comment", rt.wrap(rt.limitByUser(RateLimitMessaging, rt.commentMessage)))
 	rt.router.DELETE("/messages/:messageId/comment", rt.wrap(rt.uncommentMessage))
 
 	// Group routes
@@ -36,6 +91,38 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.DELETE("/groups/:groupId/members/:userId", rt.wrap(rt.leaveGroup))
 	rt.router.PUT("/groups/:groupId/name", rt.wrap(rt.setGroupName))
 	rt.router.PUT("/groups/:groupId/photo", rt.wrap(rt.setGroupPhoto))
+	rt.router.PUT("/groups/:groupId/admins/:userId", rt.wrap(rt.addGroupAdmin))
+	rt.router.DELETE("/groups/:groupId/admins/:userId", rt.wrap(rt.removeGroupAdmin))
+	rt.router.GET("/groups/:groupId/incoming-webhooks", rt.wrap(rt.getIncomingWebhooks))
+	rt.router.POST("/groups/:groupId/incoming-webhooks", rt.wrap(rt.createIncomingWebhook))
+	rt.router.PUT("/groups/:groupId/incoming-webhooks/:hookId", rt.wrap(rt.updateIncomingWebhook))
+	rt.router.DELETE("/groups/:groupId/incoming-webhooks/:hookId", rt.wrap(rt.deleteIncomingWebhook))
+	rt.router.POST("/groups/:groupId/incoming-webhooks/:hookId/rotate", rt.wrap(rt.rotateIncomingWebhookToken))
+
+	// Webhook routes
+	rt.router.GET("/conversations/:conversationId/webhooks", rt.wrap(rt.getWebhooks))
+	rt.router.POST("/conversations/:conversationId/webhooks", rt.wrap(rt.createWebhook))
+	rt.router.DELETE("/conversations/:conversationId/webhooks/:webhookId", rt.wrap(rt.deleteWebhook))
+	rt.router.GET("/conversations/:conversationId/webhooks/:webhookId/deliveries", rt.wrap(rt.getWebhookDeliveries))
+	rt.router.POST("/conversations/:conversationId/webhooks/:webhookId/deliveries/:deliveryId/redeliver", rt.wrap(rt.redeliverWebhook))
+
+	// Administration routes
+	rt.router.GET("/admin/users", rt.wrapAdmin(rt.listUsers))
+	rt.router.POST("/admin/users/:userId/suspend", rt.wrapAdmin(rt.suspendUser))
+	rt.router.POST("/admin/users/:userId/unsuspend", rt.wrapAdmin(rt.unsuspendUser))
+	rt.router.PUT("/admin/users/:userId/username", rt.wrapAdmin(rt.renameUser))
+	rt.router.PUT("/admin/users/:userId/admin", rt.wrapAdmin(rt.grantAdmin))
+	rt.router.DELETE("/admin/users/:userId/admin", rt.wrapAdmin(rt.revokeAdmin))
+	rt.router.DELETE("/admin/groups/:groupId", rt.wrapAdmin(rt.deleteGroup))
+	rt.router.GET("/admin/stats", rt.wrapAdmin(rt.getServerStats))
+	rt.router.GET("/admin/audit", rt.wrapAdmin(rt.getAuditLog))
+	rt.router.GET("/admin/reports", rt.wrapAdmin(rt.getReports))
+	rt.router.GET("/admin/reports/:reportId", rt.wrapAdmin(rt.getReport))
+	rt.router.GET("/admin/reports/:reportId/media/:position", rt.wrapAdmin(rt.getReportMedia))
+	rt.router.POST("/admin/reports/:reportId/decisions", rt.wrapAdmin(rt.decideReport))
+
+	// Incoming webhooks - the token in the URL authenticates
+	rt.router.POST("/hooks/:token", rt.postIncomingMessage)
 
 	// Liveness check
 	rt.router.GET("/liveness", rt.liveness)
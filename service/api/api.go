@@ -4,11 +4,19 @@ Package api exposes the main API engine. All HTTP APIs are handled here.
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"net/url"
+	"sync"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/bot"
 	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/oidc"
+	"github.com/sapienzaapps/wasatext/service/ratelimit"
+	"github.com/sapienzaapps/wasatext/service/webhook"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +24,46 @@ import (
 type Config struct {
 	Logger   logrus.FieldLogger
 	Database database.AppDatabase
+
+	// UploadLimits maps uploadable message types to their maximum upload size in bytes.
+	// Missing or zero entries fall back to the defaults, unknown types are ignored.
+	UploadLimits map[string]int64
+
+	// UserQuota and ConversationQuota cap the bytes of media stored per user and
+	// per conversation. Zero means unlimited.
+	UserQuota         int64
+	ConversationQuota int64
+
+	// RateLimits maps rate limit names (RateLimitLogin, ...) to their limit. Missing or
+	// zero entries fall back to the defaults, unknown names are ignored.
+	RateLimits map[string]ratelimit.Limit
+
+	// TrustedProxies is the number of reverse proxies in front of the server, each
+	// appending the address it received the request from to X-Forwarded-For. The
+	// client address is then taken from that many entries from the end of the header,
+	// which the client can't forge. Zero ignores the header.
+	TrustedProxies int
+
+	// OIDC enables login through an OpenID Connect identity provider when not nil
+	OIDC *oidc.Config
+
+	// OIDCPostLoginURL is where the browser is sent after a single sign-on, with the
+	// session in the URL fragment. When empty the session is returned as JSON.
+	OIDCPostLoginURL string
+
+	// WebhookAllowHTTP accepts plain HTTP webhook and bot endpoint URLs, for local development
+	WebhookAllowHTTP bool
+
+	// WebhookAllowPrivate lets webhooks and bot endpoints reach loopback and private
+	// addresses, for local development
+	WebhookAllowPrivate bool
+
+	// BotHandlers serves bots from within the server, by bot user ID. Other bots are
+	// served by the endpoint their owner sets.
+	BotHandlers map[string]bot.Handler
+
+	// AuditLog records security-relevant actions. When nil a log writing to Database is used.
+	AuditLog *audit.Log
 }
 
 // Router is the package API interface representing an API handler builder
@@ -33,23 +81,109 @@ func New(cfg Config) (Router, error) {
 		return nil, errors.New("database is required")
 	}
 
+	uploadLimits := make(map[string]int64, len(defaultUploadLimits))
+	for msgType, limit := range defaultUploadLimits {
+		uploadLimits[msgType] = limit
+	}
+	for msgType, limit := range cfg.UploadLimits {
+		if _, ok := uploadLimits[msgType]; ok && limit > 0 {
+			uploadLimits[msgType] = limit
+		}
+	}
+
+	limiters := make(map[string]*ratelimit.Limiter, len(defaultRateLimits))
+	for name, limit := range defaultRateLimits {
+		if configured, ok := cfg.RateLimits[name]; ok && configured.Rate > 0 && configured.Burst > 0 {
+			limit = configured
+		}
+		limiters[name] = ratelimit.New(limit)
+	}
+
+	var oidcProvider *oidc.Provider
+	var oidcRedirect *url.URL
+	if cfg.OIDC != nil {
+		if cfg.OIDC.Issuer == "" || cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "" {
+			return nil, errors.New("OIDC issuer, client ID and redirect URL are required")
+		}
+		var err error
+		if oidcRedirect, err = url.Parse(cfg.OIDC.RedirectURL); err != nil || oidcRedirect.Host == "" {
+			return nil, errors.New("OIDC redirect URL must be an absolute URL")
+		}
+		oidcProvider = oidc.New(*cfg.OIDC)
+	}
+
+	auditLog := cfg.AuditLog
+	if auditLog == nil {
+		auditLog = audit.New(cfg.Database)
+	}
+
+	botContext, stopBots := context.WithCancel(context.Background())
+
 	router := httprouter.New()
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
 	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
+		router:       router,
+		baseLogger:   cfg.Logger,
+		db:           cfg.Database,
+		uploadLimits: uploadLimits,
+
+		userQuota:         cfg.UserQuota,
+		conversationQuota: cfg.ConversationQuota,
+
+		limiters:       limiters,
+		trustedProxies: cfg.TrustedProxies,
+
+		oidc:             oidcProvider,
+		oidcRedirect:     oidcRedirect,
+		oidcPostLoginURL: cfg.OIDCPostLoginURL,
+
+		webhooks:            webhook.NewDispatcher(cfg.Database, cfg.Logger, cfg.WebhookAllowPrivate),
+		webhookAllowHTTP:    cfg.WebhookAllowHTTP,
+		webhookAllowPrivate: cfg.WebhookAllowPrivate,
+
+		botHandlers: cfg.BotHandlers,
+		// Calls to bots are bounded by the context of each command
+		botClient:  webhook.NewClient(0, cfg.WebhookAllowPrivate),
+		botContext: botContext,
+		stopBots:   stopBots,
+
+		auditLog: auditLog,
 	}, nil
 }
 
 type _router struct {
-	router     *httprouter.Router
-	baseLogger logrus.FieldLogger
-	db         database.AppDatabase
+	router       *httprouter.Router
+	baseLogger   logrus.FieldLogger
+	db           database.AppDatabase
+	uploadLimits map[string]int64
+
+	userQuota         int64
+	conversationQuota int64
+
+	limiters       map[string]*ratelimit.Limiter
+	trustedProxies int
+
+	oidc             *oidc.Provider
+	oidcRedirect     *url.URL // callback URL, where the binding cookie of a login is sent back
+	oidcPostLoginURL string
+
+	webhooks            *webhook.Dispatcher
+	webhookAllowHTTP    bool
+	webhookAllowPrivate bool
+
+	botHandlers map[string]bot.Handler
+	botClient   *http.Client
+	botContext  context.Context // canceled by Close to stop the calls to bots
+	stopBots    context.CancelFunc
+	botTasks    sync.WaitGroup
+
+	auditLog *audit.Log
 }
 
 func (rt *_router) Close() error {
-	return nil
+	rt.stopBots()
+	rt.botTasks.Wait()
+	return rt.webhooks.Close()
 }
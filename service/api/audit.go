@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+type auditEntryResponse struct {
+	Seq       int64           `json:"seq"`
+	CreatedAt string          `json:"createdAt"`
+	ActorID   string          `json:"actorId,omitempty"`
+	Action    string          `json:"action"`
+	TargetID  string          `json:"targetId,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	Details   json.RawMessage `json:"details"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+type auditLogResponse struct {
+	Entries    []auditEntryResponse `json:"entries"`
+	NextBefore int64                `json:"nextBefore,omitempty"`
+}
+
+// audit records a security-relevant action. Failures are logged, the action itself
+// already happened.
+func (rt *_router) audit(r *http.Request, actorID, action, targetID string, details audit.Details) {
+	err := rt.auditLog.Record(audit.Entry{
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		IP:       rt.clientIP(r),
+		Details:  details,
+	})
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("action", action).Error("error recording audit entry")
+	}
+}
+
+// getAuditLog lists the audit log, newest first, filtered by actor, action, target and time
+func (rt *_router) getAuditLog(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		ActorID:  query.Get("actor"),
+		Action:   query.Get("action"),
+		TargetID: query.Get("target"),
+	}
+
+	for _, bound := range []struct {
+		name string
+		dest *string
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid "+bound.name+", expected an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*bound.dest = t.UTC().Format(audit.TimeFormat)
+	}
+
+	if value := query.Get("before"); value != "" {
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 1 {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		filter.BeforeSeq = seq
+	}
+
+	// Fetch one extra entry to know whether there is a next page
+	entries, err := rt.db.GetAuditEntries(filter, auditPageSize+1)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting audit log")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := auditLogResponse{Entries: []auditEntryResponse{}}
+	if len(entries) > auditPageSize {
+		entries = entries[:auditPageSize]
+		response.NextBefore = entries[auditPageSize-1].Seq
+	}
+	for _, e := range entries {
+		response.Entries = append(response.Entries, auditEntryResponse{
+			Seq:       e.Seq,
+			CreatedAt: e.CreatedAt,
+			ActorID:   e.ActorID,
+			Action:    e.Action,
+			TargetID:  e.TargetID,
+			IP:        e.IP,
+			Details:   json.RawMessage(e.Details),
+			PrevHash:  e.PrevHash,
+			Hash:      e.Hash,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
@@ -3,13 +3,39 @@ package api
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
 )
 
-// wrap wraps a handler function with authentication
-func (rt *_router) wrap(fn func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext)) httprouter.Handle {
+// wrap wraps a handler function with authentication. The token is either a session
+// token from login or, for users without a password, their user ID. Bot API keys are
+// refused, routes open to bots use wrapKey.
+func (rt *_router) wrap(fn authenticatedHandler) httprouter.Handle {
+	return rt.authenticate("", fn)
+}
+
+// wrapAdmin is wrap for routes reserved to server admins
+func (rt *_router) wrapAdmin(fn authenticatedHandler) httprouter.Handle {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if !ctx.Admin {
+			http.Error(w, "Forbidden - server admins only", http.StatusForbidden)
+			return
+		}
+		fn(w, r, ps, ctx)
+	})
+}
+
+// wrapKey is wrap for routes bots may also call, with an API key holding scope
+func (rt *_router) wrapKey(scope string, fn authenticatedHandler) httprouter.Handle {
+	return rt.authenticate(scope, fn)
+}
+
+// authenticate wraps a handler with authentication, accepting API keys with scope
+// when scope is not empty
+func (rt *_router) authenticate(scope string, fn authenticatedHandler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Extract Bearer token from Authorization header
 		authHeader := r.Header.Get("Authorization")
@@ -30,22 +56,148 @@ func (rt *_router) wrap(fn func(w http.ResponseWriter, r *http.Request, ps httpr
 			return
 		}
 
-		// Verify user exists (token is the user ID)
-		user, err := rt.db.GetUserByID(token)
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			key, ok := rt.checkAPIKey(w, token, scope, ps)
+			if !ok {
+				return
+			}
+			ctx := reqcontext.RequestContext{UserID: key.BotID, APIKey: key}
+			if _, ok := rt.activeUser(w, ctx.UserID); !ok {
+				return
+			}
+			if !rt.allow(w, RateLimitAPI, ctx.UserID) {
+				return
+			}
+			fn(w, r, ps, ctx)
+			return
+		}
+
+		ctx := reqcontext.RequestContext{
+			TokenHash: hashToken(token),
+		}
+		now := time.Now().UTC()
+		userID, err := rt.db.UseSession(ctx.TokenHash,
+			now.Add(-sessionMaxAge).Format(time.RFC3339), now.Add(-sessionIdleTimeout).Format(time.RFC3339))
 		if err != nil {
-			rt.baseLogger.WithError(err).Error("error checking user")
+			rt.baseLogger.WithError(err).Error("error checking session")
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		if user == nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+
+		if userID != "" {
+			user, ok := rt.activeUser(w, userID)
+			if !ok {
+				return
+			}
+			ctx.UserID = userID
+			ctx.Admin = user.Admin
+		} else {
+			// Not a session, verify user exists (token is the user ID)
+			user, err := rt.db.GetUserByID(token)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error checking user")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if user == nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			// Bots authenticate with API keys only
+			if user.Bot {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			// User IDs are public, they stop being tokens once a password is set. Admins
+			// always have one, so their ID is never enough.
+			if user.Admin {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			password, err := rt.db.GetPassword(user.ID)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error getting password")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if password != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			// Neither do they for accounts linked to an identity provider
+			linked, err := rt.db.HasOIDCIdentity(user.ID)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error checking single sign-on identity")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if linked {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			if !rt.checkSuspended(w, user) {
+				return
+			}
+			ctx.UserID = user.ID
+			ctx.Admin = user.Admin
+			ctx.TokenHash = ""
 		}
 
-		ctx := reqcontext.RequestContext{
-			UserID: token,
+		if !rt.allow(w, RateLimitAPI, ctx.UserID) {
+			return
 		}
 
 		fn(w, r, ps, ctx)
 	}
 }
+
+// activeUser loads the authenticated user, rejecting suspended accounts
+func (rt *_router) activeUser(w http.ResponseWriter, userID string) (*database.User, bool) {
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, rt.checkSuspended(w, user)
+}
+
+// checkSuspended writes 403 and returns false when the account, or the owner of the
+// bot, is suspended
+func (rt *_router) checkSuspended(w http.ResponseWriter, user *database.User) bool {
+	suspended, err := rt.isSuspended(user)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking bot owner")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if suspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// isSuspended reports whether the account, or the owner of the bot, is suspended
+func (rt *_router) isSuspended(user *database.User) (bool, error) {
+	if user.SuspendedAt != "" {
+		return true, nil
+	}
+	if !user.Bot {
+		return false, nil
+	}
+
+	owner, err := rt.db.GetUserByID(user.OwnerID)
+	if err != nil {
+		return false, err
+	}
+	return owner != nil && owner.SuspendedAt != "", nil
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+)
+
+// blockUser adds a user to the requester's blocklist
+func (rt *_router) blockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+	targetID := ps.ByName("targetId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if targetID == userID {
+		http.Error(w, "Cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	// Check if target user exists
+	targetUser, err := rt.db.GetUserByID(targetID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if targetUser == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := rt.db.BlockUser(userID, targetID); err != nil {
+		rt.baseLogger.WithError(err).Error("error blocking user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unblockUser removes a user from the requester's blocklist
+func (rt *_router) unblockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := rt.db.UnblockUser(userID, ps.ByName("targetId")); err != nil {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMyBlocks lists the users the requester blocked
+func (rt *_router) getMyBlocks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	users, err := rt.db.GetBlockedUsers(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting blocked users")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]userResponse, len(users))
+	for i, u := range users {
+		response[i] = userResponse{
+			ID:       u.ID,
+			Username: u.Username,
+			PhotoURL: rt.userPhotoURL(&u, ctx.UserID),
+			Bot:      u.Bot,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// checkNotBlocked verifies that the other member of a private conversation did not block
+// userID. On failure it writes the error response and returns false.
+func (rt *_router) checkNotBlocked(w http.ResponseWriter, conversationID, userID string) bool {
+	blocked, err := rt.db.IsBlockedInPrivateConversation(conversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking blocks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if blocked {
+		http.Error(w, "Forbidden - blocked by this user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
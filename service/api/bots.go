@@ -0,0 +1,332 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+type createBotRequest struct {
+	Username string `json:"username"`
+}
+
+type apiKeyRequest struct {
+	Name            string   `json:"name"`
+	Scopes          []string `json:"scopes"`
+	ConversationIDs []string `json:"conversationIds"`
+}
+
+type apiKeyResponse struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Prefix          string   `json:"prefix"`
+	Key             string   `json:"key,omitempty"`
+	Scopes          []string `json:"scopes"`
+	ConversationIDs []string `json:"conversationIds"`
+	CreatedAt       string   `json:"createdAt"`
+	LastUsedAt      string   `json:"lastUsedAt,omitempty"`
+}
+
+// isScope reports whether s is a known API key scope
+func isScope(s string) bool {
+	switch s {
+	case database.ScopeConversationsRead, database.ScopeMessagesSend:
+		return true
+	}
+	return false
+}
+
+// hasScope reports whether an API key holds scope
+func hasScope(key *database.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// keyAllowsConversation reports whether a request may touch a conversation. Users and
+// unrestricted keys may touch any conversation they are a member of.
+func keyAllowsConversation(ctx reqcontext.RequestContext, conversationID string) bool {
+	if ctx.APIKey == nil || len(ctx.APIKey.ConversationIDs) == 0 {
+		return true
+	}
+	for _, id := range ctx.APIKey.ConversationIDs {
+		if id == conversationID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAPIKey looks up the key a bot authenticates with and checks that it may be used
+// for a route requiring scope. On failure it writes an error and returns false.
+func (rt *_router) checkAPIKey(w http.ResponseWriter, token, scope string, ps httprouter.Params) (*database.APIKey, bool) {
+	key, err := rt.db.GetAPIKeyByHash(hashToken(token))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking api key")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if key == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	if scope == "" || !hasScope(key, scope) {
+		http.Error(w, "API key not allowed for this request", http.StatusForbidden)
+		return nil, false
+	}
+	ctx := reqcontext.RequestContext{APIKey: key}
+	if conversationID := ps.ByName("conversationId"); conversationID != "" && !keyAllowsConversation(ctx, conversationID) {
+		http.Error(w, "API key not allowed for this conversation", http.StatusForbidden)
+		return nil, false
+	}
+
+	if err := rt.db.MarkAPIKeyUsed(key.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error marking api key used")
+	}
+	return key, true
+}
+
+// getOwnedBot loads a bot created by userID, or writes an error and returns nil
+func (rt *_router) getOwnedBot(w http.ResponseWriter, botID, userID string) *database.User {
+	bot, err := rt.db.GetUserByID(botID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bot")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if bot == nil || !bot.Bot || bot.OwnerID != userID {
+		http.Error(w, "Bot not found", http.StatusNotFound)
+		return nil
+	}
+	return bot
+}
+
+// newAPIKeyResponse converts an API key to its API representation
+func newAPIKeyResponse(key *database.APIKey) apiKeyResponse {
+	response := apiKeyResponse{
+		ID:              key.ID,
+		Name:            key.Name,
+		Prefix:          key.Prefix,
+		Scopes:          key.Scopes,
+		ConversationIDs: key.ConversationIDs,
+		CreatedAt:       key.CreatedAt,
+		LastUsedAt:      key.LastUsedAt,
+	}
+	if response.ConversationIDs == nil {
+		response.ConversationIDs = []string{}
+	}
+	return response
+}
+
+// createBot creates a bot user owned by the user
+func (rt *_router) createBot(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req createBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !usernameRegex.MatchString(req.Username) {
+		http.Error(w, "Invalid username format", http.StatusBadRequest)
+		return
+	}
+
+	existingUser, err := rt.db.GetUserByUsername(req.Username)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking username")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if existingUser != nil {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+
+	botID := uuid.New().String()
+	if err := rt.db.CreateBot(botID, req.Username, userID); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating bot")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(userResponse{ID: botID, Username: req.Username, Bot: true}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getMyBots returns the bots created by the user
+func (rt *_router) getMyBots(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	bots, err := rt.db.GetBots(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bots")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]userResponse, len(bots))
+	for i, b := range bots {
+		response[i] = userResponse{
+			ID:       b.ID,
+			Username: b.Username,
+			PhotoURL: rt.userPhotoURL(&b, ctx.UserID),
+			Bot:      true,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// createAPIKey issues a key for a bot. The key is returned only now, it is stored hashed.
+func (rt *_router) createAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	bot := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if bot == nil {
+		return
+	}
+
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Name) == 0 || len(req.Name) > maxAPIKeyNameLength {
+		http.Error(w, "Name must be 1-64 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 || hasDuplicates(req.Scopes) {
+		http.Error(w, "Invalid scopes", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !isScope(scope) {
+			http.Error(w, "Invalid scopes", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.ConversationIDs) > maxAPIKeyConversations || hasDuplicates(req.ConversationIDs) {
+		http.Error(w, "Restrict a key to at most 20 distinct conversations", http.StatusBadRequest)
+		return
+	}
+
+	// Keys can only be restricted to conversations the owner is in
+	for _, conversationID := range req.ConversationIDs {
+		isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error checking membership")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !isMember {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		rt.baseLogger.WithError(err).Error("error generating api key")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(b)
+
+	key := database.APIKey{
+		ID:              uuid.New().String(),
+		BotID:           bot.ID,
+		Name:            req.Name,
+		Prefix:          secret[:apiKeyDisplayLength],
+		Scopes:          req.Scopes,
+		ConversationIDs: req.ConversationIDs,
+	}
+	if err := rt.db.CreateAPIKey(&key, hashToken(secret)); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating api key")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	created, err := rt.db.GetAPIKeyByHash(hashToken(secret))
+	if err != nil || created == nil {
+		rt.baseLogger.WithError(err).Error("error getting api key")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := newAPIKeyResponse(created)
+	response.Key = secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getAPIKeys lists the keys of a bot, without their secrets
+func (rt *_router) getAPIKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	bot := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if bot == nil {
+		return
+	}
+
+	keys, err := rt.db.GetAPIKeys(bot.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting api keys")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]apiKeyResponse, len(keys))
+	for i := range keys {
+		response[i] = newAPIKeyResponse(&keys[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// revokeAPIKey stops a key of a bot from working
+func (rt *_router) revokeAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	bot := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if bot == nil {
+		return
+	}
+
+	if err := rt.db.RevokeAPIKey(bot.ID, ps.ByName("keyId")); err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAPIKeyRevoked, bot.ID, audit.Details{"key": ps.ByName("keyId")})
+
+	w.WriteHeader(http.StatusNoContent)
+}
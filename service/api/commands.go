@@ -0,0 +1,504 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/bot"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type botCommandRequest struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+type setBotCommandsRequest struct {
+	Commands []botCommandRequest `json:"commands"`
+}
+
+type botCommandResponse struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+	BotID       string `json:"botId"`
+	BotUsername string `json:"botUsername"`
+}
+
+type botEndpointRequest struct {
+	URL string `json:"url"`
+}
+
+type botEndpointResponse struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type buttonResponse struct {
+	Text string `json:"text"`
+}
+
+type pressButtonRequest struct {
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+// newButtonResponses converts the buttons of a message, leaving out their data
+func newButtonResponses(buttons [][]database.MessageButton) [][]buttonResponse {
+	if len(buttons) == 0 {
+		return nil
+	}
+	rows := make([][]buttonResponse, len(buttons))
+	for i, row := range buttons {
+		rows[i] = make([]buttonResponse, len(row))
+		for j, b := range row {
+			rows[i][j] = buttonResponse{Text: b.Text}
+		}
+	}
+	return rows
+}
+
+// toMessageButtons converts the buttons of a bot reply for storage
+func toMessageButtons(buttons [][]bot.Button) [][]database.MessageButton {
+	rows := make([][]database.MessageButton, len(buttons))
+	for i, row := range buttons {
+		rows[i] = make([]database.MessageButton, len(row))
+		for j, b := range row {
+			rows[i][j] = database.MessageButton{Text: b.Text, Data: b.Data}
+		}
+	}
+	return rows
+}
+
+func newBotCommandResponses(commands []database.BotCommand) []botCommandResponse {
+	response := make([]botCommandResponse, len(commands))
+	for i, c := range commands {
+		response[i] = botCommandResponse{
+			Command:     c.Command,
+			Description: c.Description,
+			BotID:       c.BotID,
+			BotUsername: c.BotUsername,
+		}
+	}
+	return response
+}
+
+// botHandler returns what serves a bot: a handler compiled into the server, or its
+// endpoint. It returns nil for bots that are not served.
+func (rt *_router) botHandler(botID string) (bot.Handler, error) {
+	if h, ok := rt.botHandlers[botID]; ok {
+		return h, nil
+	}
+	endpoint, err := rt.db.GetBotEndpoint(botID)
+	if err != nil || endpoint == nil {
+		return nil, err
+	}
+	return &bot.WebhookHandler{URL: endpoint.URL, Secret: endpoint.Secret, Client: rt.botClient}, nil
+}
+
+// botCanPost reports whether a bot can still post in a conversation: it is a member,
+// and neither it nor its owner is suspended
+func (rt *_router) botCanPost(botID, conversationID string) (bool, error) {
+	isMember, err := rt.db.IsConversationMember(conversationID, botID)
+	if err != nil || !isMember {
+		return false, err
+	}
+	b, err := rt.db.GetUserByID(botID)
+	if err != nil || b == nil {
+		return false, err
+	}
+	suspended, err := rt.isSuspended(b)
+	return !suspended, err
+}
+
+// runBotTask runs a call to a bot in the background, bounded by botTimeout. Close
+// cancels the calls in progress and waits for them.
+func (rt *_router) runBotTask(task func(ctx context.Context)) {
+	rt.botTasks.Add(1)
+	go func() {
+		defer rt.botTasks.Done()
+		ctx, cancel := context.WithTimeout(rt.botContext, botTimeout)
+		defer cancel()
+		task(ctx)
+	}()
+}
+
+// dispatchCommand sends a slash command to the bots of the conversation that answer
+// it. Bot replies are posted as answers to the command.
+func (rt *_router) dispatchCommand(msg *database.Message, sender *database.User) {
+	if msg.Type != MessageTypeText || sender == nil || sender.Bot {
+		return
+	}
+	name, botUsername, args, ok := bot.ParseCommand(msg.Content)
+	if !ok {
+		return
+	}
+
+	commands, err := rt.db.GetConversationCommands(msg.ConversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting conversation commands")
+		return
+	}
+
+	for _, c := range commands {
+		if c.Command != name || (botUsername != "" && !strings.EqualFold(c.BotUsername, botUsername)) {
+			continue
+		}
+		cmd := bot.Command{
+			BotID:          c.BotID,
+			ConversationID: msg.ConversationID,
+			MessageID:      msg.ID,
+			UserID:         sender.ID,
+			Username:       sender.Username,
+			Name:           name,
+			Args:           args,
+		}
+		rt.runBotTask(func(ctx context.Context) { rt.runCommand(ctx, cmd) })
+	}
+}
+
+// runCommand calls the bot of a command and posts its reply
+func (rt *_router) runCommand(ctx context.Context, cmd bot.Command) {
+	logger := rt.baseLogger.WithField("bot", cmd.BotID).WithField("command", cmd.Name)
+
+	h, err := rt.botHandler(cmd.BotID)
+	if err != nil {
+		logger.WithError(err).Error("error getting bot handler")
+		return
+	}
+	if h == nil {
+		return
+	}
+
+	reply, err := h.HandleCommand(ctx, cmd)
+	if err != nil {
+		logger.WithError(err).Warning("bot failed to handle command")
+		return
+	}
+	if reply == nil {
+		return
+	}
+	if err := reply.Validate(); err != nil {
+		logger.WithError(err).Warning("invalid bot reply")
+		return
+	}
+	// The bot may have been removed or suspended while it handled the command
+	canPost, err := rt.botCanPost(cmd.BotID, cmd.ConversationID)
+	if err != nil {
+		logger.WithError(err).Error("error checking bot")
+		return
+	}
+	if !canPost {
+		logger.Debug("dropping the reply of a bot that can no longer post")
+		return
+	}
+
+	msg := database.Message{
+		ID:             uuid.New().String(),
+		ConversationID: cmd.ConversationID,
+		SenderID:       cmd.BotID,
+		Content:        reply.Text,
+		Type:           MessageTypeText,
+		ReplyToID:      cmd.MessageID,
+		Buttons:        toMessageButtons(reply.Buttons),
+	}
+	if err := rt.db.CreateMessage(&msg); err != nil {
+		logger.WithError(err).Error("error creating bot reply")
+		return
+	}
+	rt.publishMessage(cmd.ConversationID, webhook.EventMessageCreated, msg.ID)
+}
+
+// runCallback calls the bot of a pressed button and updates its message with the reply
+func (rt *_router) runCallback(ctx context.Context, h bot.Handler, cb bot.Callback) {
+	logger := rt.baseLogger.WithField("bot", cb.BotID).WithField("message", cb.MessageID)
+
+	reply, err := h.HandleCallback(ctx, cb)
+	if err != nil {
+		logger.WithError(err).Warning("bot failed to handle button press")
+		return
+	}
+	if reply == nil {
+		return
+	}
+	if err := reply.Validate(); err != nil {
+		logger.WithError(err).Warning("invalid bot reply")
+		return
+	}
+	canPost, err := rt.botCanPost(cb.BotID, cb.ConversationID)
+	if err != nil {
+		logger.WithError(err).Error("error checking bot")
+		return
+	}
+	if !canPost {
+		logger.Debug("dropping the reply of a bot that can no longer post")
+		return
+	}
+
+	if err := rt.db.UpdateBotMessage(cb.MessageID, reply.Text, toMessageButtons(reply.Buttons)); err != nil {
+		logger.WithError(err).Error("error updating bot message")
+		return
+	}
+	rt.publishMessage(cb.ConversationID, webhook.EventMessageUpdated, cb.MessageID)
+}
+
+// pressButton delivers the press of an inline button to the bot that sent the message
+func (rt *_router) pressButton(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := rt.db.IsConversationMember(msg.ConversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	var req pressButtonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Row < 0 || req.Row >= len(msg.Buttons) || req.Column < 0 || req.Column >= len(msg.Buttons[req.Row]) {
+		http.Error(w, "Button not found", http.StatusNotFound)
+		return
+	}
+	button := msg.Buttons[req.Row][req.Column]
+
+	h, err := rt.botHandler(msg.SenderID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bot handler")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if h == nil {
+		http.Error(w, "The bot is not available", http.StatusConflict)
+		return
+	}
+	canPost, err := rt.botCanPost(msg.SenderID, msg.ConversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking bot")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !canPost {
+		http.Error(w, "The bot is not available", http.StatusConflict)
+		return
+	}
+
+	user, err := rt.db.GetUserByID(ctx.UserID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	cb := bot.Callback{
+		BotID:          msg.SenderID,
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		UserID:         user.ID,
+		Username:       user.Username,
+		Data:           button.Data,
+	}
+	rt.runBotTask(func(ctx context.Context) { rt.runCallback(ctx, h, cb) })
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getConversationCommands lists the commands of the bots in a conversation
+func (rt *_router) getConversationCommands(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	commands, err := rt.db.GetConversationCommands(conversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting conversation commands")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newBotCommandResponses(commands)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getBotCommands lists the commands of a bot
+func (rt *_router) getBotCommands(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	b := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if b == nil {
+		return
+	}
+
+	commands, err := rt.db.GetBotCommands(b.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bot commands")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newBotCommandResponses(commands)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// setBotCommands replaces the commands a bot answers
+func (rt *_router) setBotCommands(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	b := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if b == nil {
+		return
+	}
+
+	var req setBotCommandsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Commands) > maxBotCommands {
+		http.Error(w, "A bot can have at most 50 commands", http.StatusBadRequest)
+		return
+	}
+
+	commands := make([]database.BotCommand, len(req.Commands))
+	names := make([]string, len(req.Commands))
+	for i, c := range req.Commands {
+		if !bot.ValidCommandName(c.Command) {
+			http.Error(w, "Commands must be 1-32 lowercase letters, digits or underscores", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(c.Description) > maxBotCommandDescriptionLength {
+			http.Error(w, "Description must be at most 256 characters", http.StatusBadRequest)
+			return
+		}
+		commands[i] = database.BotCommand{Command: c.Command, Description: c.Description}
+		names[i] = c.Command
+	}
+	if hasDuplicates(names) {
+		http.Error(w, "Duplicate command", http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.SetBotCommands(b.ID, commands); err != nil {
+		rt.baseLogger.WithError(err).Error("error setting bot commands")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := rt.db.GetBotCommands(b.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bot commands")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newBotCommandResponses(stored)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getBotEndpoint returns the endpoint of a bot, without its secret
+func (rt *_router) getBotEndpoint(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	b := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if b == nil {
+		return
+	}
+
+	endpoint, err := rt.db.GetBotEndpoint(b.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting bot endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if endpoint == nil {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(botEndpointResponse{URL: endpoint.URL}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// setBotEndpoint sets the URL a bot receives its commands and button presses at, with
+// a new signing secret that is only returned here
+func (rt *_router) setBotEndpoint(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	b := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if b == nil {
+		return
+	}
+
+	var req botEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !rt.validWebhookURL(req.URL) {
+		rt.invalidWebhookURL(w)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating endpoint secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.SetBotEndpoint(b.ID, req.URL, secret); err != nil {
+		rt.baseLogger.WithError(err).Error("error setting bot endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(botEndpointResponse{URL: req.URL, Secret: secret}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// deleteBotEndpoint stops sending commands and button presses to a bot
+func (rt *_router) deleteBotEndpoint(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	b := rt.getOwnedBot(w, ps.ByName("botId"), ctx.UserID)
+	if b == nil {
+		return
+	}
+
+	if err := rt.db.DeleteBotEndpoint(b.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting bot endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
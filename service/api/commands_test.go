@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+// botDB holds a bot, its owner and the members of one conversation
+type botDB struct {
+	database.AppDatabase
+	users   map[string]*database.User
+	members map[string]bool
+}
+
+func (db *botDB) IsConversationMember(_, userID string) (bool, error) {
+	return db.members[userID], nil
+}
+
+func (db *botDB) GetUserByID(id string) (*database.User, error) {
+	return db.users[id], nil
+}
+
+func TestBotCanPost(t *testing.T) {
+	tests := []struct {
+		name           string
+		member         bool
+		botSuspended   bool
+		ownerSuspended bool
+		want           bool
+	}{
+		{"member", true, false, false, true},
+		{"removed from the conversation", false, false, false, false},
+		{"bot suspended", true, true, false, false},
+		{"owner suspended", true, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &database.User{ID: "bot", Bot: true, OwnerID: "owner"}
+			owner := &database.User{ID: "owner"}
+			if tt.botSuspended {
+				b.SuspendedAt = "2026-01-01T00:00:00Z"
+			}
+			if tt.ownerSuspended {
+				owner.SuspendedAt = "2026-01-01T00:00:00Z"
+			}
+			db := &botDB{
+				users:   map[string]*database.User{"bot": b, "owner": owner},
+				members: map[string]bool{"bot": tt.member},
+			}
+			rt := &_router{db: db}
+
+			got, err := rt.botCanPost("bot", "group")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("botCanPost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
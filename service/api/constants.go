@@ -1,4 +1,194 @@
 package api
 
+import (
+	"time"
+
+	"github.com/sapienzaapps/wasatext/service/ratelimit"
+)
+
 // ConversationTypeGroup is the constant for group conversation type
 const ConversationTypeGroup = "group"
+
+// Message types
+const (
+	MessageTypeText     = "text"
+	MessageTypePhoto    = "photo"
+	MessageTypeFile     = "file"
+	MessageTypeAudio    = "audio"
+	MessageTypeAlbum    = "album"
+	MessageTypePoll     = "poll"
+	MessageTypeLocation = "location"
+	MessageTypeContact  = "contact"
+)
+
+// defaultUploadLimits are the maximum upload sizes in bytes per message type
+var defaultUploadLimits = map[string]int64{
+	MessageTypePhoto: 5 * 1024 * 1024,
+	MessageTypeFile:  25 * 1024 * 1024,
+	MessageTypeAudio: 10 * 1024 * 1024,
+	MessageTypeAlbum: 50 * 1024 * 1024, // whole album, each photo is also bound by the photo limit
+}
+
+// Names of the rate limits applied to routes
+const (
+	RateLimitLogin           = "login"            // POST /session, per client IP
+	RateLimitMessaging       = "messaging"        // starting conversations, sending, forwarding and reacting, per user
+	RateLimitAPI             = "api"              // every authenticated route, per user
+	RateLimitIncomingWebhook = "incoming-webhook" // messages posted through an incoming webhook, per hook
+)
+
+// defaultRateLimits are the rate limits used when the configuration does not set them
+var defaultRateLimits = map[string]ratelimit.Limit{
+	RateLimitLogin:           {Rate: 10.0 / 60, Burst: 10},
+	RateLimitMessaging:       {Rate: 1, Burst: 30},
+	RateLimitAPI:             {Rate: 10, Burst: 100},
+	RateLimitIncomingWebhook: {Rate: 20.0 / 60, Burst: 20},
+}
+
+// Limits of a poll
+const (
+	minPollOptions        = 2
+	maxPollOptions        = 10
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+)
+
+// Limits of a shared location
+const (
+	maxLocationLabelLength  = 100
+	maxLocationAccuracy     = 100000 // meters
+	minLiveLocationDuration = time.Minute
+	maxLiveLocationDuration = 8 * time.Hour
+)
+
+// After a message request is declined, its sender can't send the user a new one for
+// this long
+const requestDeclineCooldown = 7 * 24 * time.Hour
+
+// Number of messages and target conversations of a bulk forward
+const (
+	maxForwardMessages = 20
+	maxForwardTargets  = 10
+)
+
+// Number of photos an album can hold
+const (
+	minAlbumItems = 2
+	maxAlbumItems = 10
+)
+
+// Limits for the optional text accompanying a photo
+const (
+	maxCaptionLength = 1024
+	maxAltTextLength = 512
+)
+
+// Length of a password in bytes, bcrypt ignores anything past 72
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// Failed logins after which a password is locked, and for how long
+const (
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+)
+
+// sessionTokenBytes is the number of random bytes in a session token
+const sessionTokenBytes = 32
+
+// Sessions end sessionIdleTimeout after they were last used, and sessionMaxAge after
+// the login at the latest
+const (
+	sessionIdleTimeout = 30 * 24 * time.Hour
+	sessionMaxAge      = 90 * 24 * time.Hour
+)
+
+// totpIssuer names the service in authenticator apps
+const totpIssuer = "WASAText"
+
+// Recovery codes issued when enabling two-factor authentication, and their length
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// totpQRCodeSize is the side of the enrolment QR code in pixels
+const totpQRCodeSize = 256
+
+// API keys are told apart from session tokens by their prefix
+const (
+	apiKeyPrefix        = "wtk_"
+	apiKeyBytes         = 32
+	apiKeyDisplayLength = 12 // characters of a key shown when listing keys
+)
+
+// Limits of an API key
+const (
+	maxAPIKeyNameLength    = 64
+	maxAPIKeyConversations = 20
+)
+
+// Limits of webhooks
+const (
+	maxWebhookURLLength        = 2048
+	maxWebhooksPerConversation = 10
+	webhookDeliveryLogSize     = 50 // latest deliveries listed per webhook
+)
+
+// Incoming webhook tokens are part of their URL
+const (
+	incomingWebhookTokenPrefix = "wih_"
+	incomingWebhookTokenBytes  = 32
+)
+
+// Limits of incoming webhooks
+const (
+	maxIncomingWebhookNameLength   = 32
+	maxIncomingWebhooksPerGroup    = 10
+	maxIncomingWebhookTextLength   = 4096
+	maxIncomingWebhookRequestBytes = 16 << 10
+)
+
+// botTimeout bounds a call to a bot handler
+const botTimeout = 10 * time.Second
+
+// Limits of the commands of a bot
+const (
+	maxBotCommands                 = 50
+	maxBotCommandDescriptionLength = 256
+)
+
+// adminUsersPageSize is the number of users listed per page to admins
+const adminUsersPageSize = 50
+
+// Reasons a message or user can be reported for
+var reportReasons = []string{"spam", "harassment", "hate", "violence", "sexual", "impersonation", "other"}
+
+// Actions an admin can take on a report
+const (
+	reportActionDismiss       = "dismiss"
+	reportActionDeleteMessage = "delete_message"
+	reportActionSuspendUser   = "suspend_user"
+)
+
+// Limits of reports
+const (
+	maxReportDetailsLength = 1000
+	maxReportNoteLength    = 1000
+	reportsPageSize        = 50
+)
+
+// auditPageSize is the number of audit log entries listed per page
+const auditPageSize = 100
+
+// deletedUsername is shown instead of the username of deleted accounts
+const deletedUsername = "Deleted user"
+
+// exportWriteTimeout replaces the server write timeout while a data export is streamed
+const exportWriteTimeout = 10 * time.Minute
+
+// transferTimeout replaces the server read and write timeouts while the media of a
+// message is uploaded or downloaded
+const transferTimeout = 5 * time.Minute
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+// setContact resolves the current profile of the user shared by a contact card as seen by viewerID
+func (rt *_router) setContact(resp *messageResponse, msg *database.Message, viewerID string) {
+	if msg.Type != MessageTypeContact || msg.ContactUserID == "" {
+		return
+	}
+
+	resp.Contact = &userResponse{ID: msg.ContactUserID}
+	user, err := rt.db.GetUserByID(msg.ContactUserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting contact")
+		return
+	}
+	if user == nil {
+		resp.Contact.Username = deletedUsername
+		resp.Content = deletedUsername
+		return
+	}
+
+	resp.Contact.Username = user.Username
+	resp.Contact.PhotoURL = rt.userPhotoURL(user, viewerID)
+	resp.Contact.Bot = user.Bot
+	resp.Content = user.Username
+}
+
+// setMessageDetails fills the fields of a message response that are resolved from other records
+// at read time, as seen by viewerID
+func (rt *_router) setMessageDetails(resp *messageResponse, msg *database.Message, viewerID string) {
+	rt.setForwardInfo(resp, msg)
+	rt.setContact(resp, msg, viewerID)
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
 )
 
 type conversationPreviewResponse struct {
@@ -19,6 +21,10 @@ type conversationPreviewResponse struct {
 
 type messagePreviewResponse struct {
 	Content   string `json:"content"`
+	Type      string `json:"type,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	AltText   string `json:"altText,omitempty"`
+	FileName  string `json:"fileName,omitempty"`
 	Timestamp string `json:"timestamp"`
 	SenderID  string `json:"senderId"`
 }
@@ -29,6 +35,7 @@ type conversationResponse struct {
 	Name     string            `json:"name"`
 	PhotoURL *string           `json:"photoUrl,omitempty"`
 	Members  []userResponse    `json:"members"`
+	Admins   []string          `json:"admins,omitempty"`
 	Messages []messageResponse `json:"messages"`
 }
 
@@ -52,6 +59,23 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
+	// Bots see only the conversations their key is restricted to
+	allowed := previews[:0]
+	for _, p := range previews {
+		if keyAllowsConversation(ctx, p.ID) {
+			allowed = append(allowed, p)
+		}
+	}
+	previews = allowed
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newConversationPreviewResponses(previews)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// newConversationPreviewResponses converts conversation previews to their API representation
+func newConversationPreviewResponses(previews []database.ConversationPreview) []conversationPreviewResponse {
 	response := make([]conversationPreviewResponse, len(previews))
 	for i, p := range previews {
 		response[i] = conversationPreviewResponse{
@@ -59,6 +83,10 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 			Type: p.Type,
 			Name: p.Name,
 		}
+		if p.Type != ConversationTypeGroup && p.Name == "" {
+			// The other member deleted their account
+			response[i].Name = deletedUsername
+		}
 		if len(p.Photo) > 0 {
 			var photoURL string
 			if p.Type == ConversationTypeGroup {
@@ -71,16 +99,16 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps
 		if p.LatestMessage != nil {
 			response[i].LatestMessage = &messagePreviewResponse{
 				Content:   p.LatestMessage.Content,
+				Type:      p.LatestMessage.Type,
+				Caption:   p.LatestMessage.Caption,
+				AltText:   p.LatestMessage.AltText,
+				FileName:  p.LatestMessage.FileName,
 				Timestamp: p.LatestMessage.Timestamp,
 				SenderID:  p.LatestMessage.SenderID,
 			}
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		rt.baseLogger.WithError(err).Error("error encoding response")
-	}
+	return response
 }
 
 // getConversation returns a conversation with messages
@@ -110,9 +138,24 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
-	// Mark as read
-	if err := rt.db.MarkConversationRead(conversationID, ctx.UserID); err != nil {
-		rt.baseLogger.WithError(err).Error("error marking conversation as read")
+	// Mark as read, unless it is a message request: the sender must not learn it was seen
+	pending, err := rt.db.IsMessageRequest(conversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking message request")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Users who turned read receipts off never mark conversations as read
+	settings, err := rt.db.GetPrivacySettings(ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !pending && settings.ReadReceipts {
+		if err := rt.db.MarkConversationRead(conversationID, ctx.UserID); err != nil {
+			rt.baseLogger.WithError(err).Error("error marking conversation as read")
+		}
 	}
 
 	// Get members
@@ -128,10 +171,18 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		memberResponses[i] = userResponse{
 			ID:       m.ID,
 			Username: m.Username,
+			PhotoURL: rt.userPhotoURL(&m, ctx.UserID),
+			Bot:      m.Bot,
 		}
-		if len(m.Photo) > 0 {
-			photoURL := "/users/" + m.ID + "/photo"
-			memberResponses[i].PhotoURL = &photoURL
+	}
+
+	var admins []string
+	if conv.Type == ConversationTypeGroup {
+		admins, err = rt.db.GetGroupAdminIDs(conversationID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting group admins")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 	}
 
@@ -151,15 +202,18 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 
 		// Get sender username
 		sender, _ := rt.db.GetUserByID(msg.SenderID)
-		senderUsername := ""
+		senderUsername := deletedUsername
+		senderBot := false
 		if sender != nil {
 			senderUsername = sender.Username
+			senderBot = sender.Bot
 		}
 
 		messageResponses[i] = messageResponse{
 			ID:             msg.ID,
 			SenderID:       msg.SenderID,
 			SenderUsername: senderUsername,
+			Bot:            senderBot,
 			Type:           msg.Type,
 			Timestamp:      msg.CreatedAt,
 			Checkmarks:     checkmarks,
@@ -167,11 +221,8 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 			Comments:       make([]commentResponse, len(comments)),
 		}
 
-		if msg.Type == "text" {
-			messageResponses[i].Content = msg.Content
-		} else {
-			messageResponses[i].Content = "/messages/" + msg.ID + "/photo"
-		}
+		setMessageContent(&messageResponses[i], &msg, ctx.UserID)
+		rt.setMessageDetails(&messageResponses[i], &msg, ctx.UserID)
 
 		for j, c := range comments {
 			messageResponses[i].Comments[j] = commentResponse{
@@ -184,14 +235,14 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		if msg.ReplyToID != "" {
 			replyTo, _ := rt.db.GetMessage(msg.ReplyToID)
 			if replyTo != nil {
-				replySender, _ := rt.db.GetUserByID(replyTo.SenderID)
-				replySenderID := replyTo.SenderID
-				if replySender != nil {
-					messageResponses[i].ReplyTo = &messagePreviewResponse{
-						Content:   replyTo.Content,
-						Timestamp: replyTo.CreatedAt,
-						SenderID:  replySenderID,
-					}
+				messageResponses[i].ReplyTo = &messagePreviewResponse{
+					Content:   replyTo.Content,
+					Type:      replyTo.Type,
+					Caption:   replyTo.Caption,
+					AltText:   replyTo.AltText,
+					FileName:  replyTo.FileName,
+					Timestamp: replyTo.CreatedAt,
+					SenderID:  replyTo.SenderID,
 				}
 			}
 		}
@@ -213,6 +264,7 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		Type:     conv.Type,
 		Name:     name,
 		Members:  memberResponses,
+		Admins:   admins,
 		Messages: messageResponses,
 	}
 
@@ -252,6 +304,17 @@ func (rt *_router) startConversation(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
+	blocked, err := rt.db.IsBlocked(req.UserID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking blocks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if blocked {
+		http.Error(w, "Forbidden - blocked by this user", http.StatusForbidden)
+		return
+	}
+
 	// Check for existing conversation
 	existing, err := rt.db.GetPrivateConversation(ctx.UserID, req.UserID)
 	if err != nil {
@@ -274,9 +337,30 @@ func (rt *_router) startConversation(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
+	// First contact from someone the target shares no conversation with is a message request
+	shares, err := rt.db.SharesConversation(ctx.UserID, req.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking shared conversations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !shares {
+		declined, err := rt.db.DeclinedRequestSince(req.UserID, ctx.UserID,
+			time.Now().Add(-requestDeclineCooldown).UTC().Format(time.RFC3339))
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error checking declined requests")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if declined {
+			http.Error(w, "Forbidden - this user recently declined your message request", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Create new conversation
 	convID := uuid.New().String()
-	err = rt.db.CreatePrivateConversation(convID, ctx.UserID, req.UserID)
+	err = rt.db.CreatePrivateConversation(convID, ctx.UserID, req.UserID, !shares)
 	if err != nil {
 		rt.baseLogger.WithError(err).Error("error creating conversation")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
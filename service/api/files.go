@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/media"
+)
+
+// multipartOverhead is the room left for form fields and part headers on top of the upload limit
+const multipartOverhead = 64 * 1024
+
+// maxUploadSize returns the largest upload limit across all message types
+func (rt *_router) maxUploadSize() int64 {
+	var largest int64
+	for _, limit := range rt.uploadLimits {
+		if limit > largest {
+			largest = limit
+		}
+	}
+	return largest
+}
+
+// extendTransferDeadlines lets an upload or download of message media outlast the
+// server timeouts, which are sized for the other requests
+func (rt *_router) extendTransferDeadlines(w http.ResponseWriter) {
+	deadline := time.Now().Add(transferTimeout)
+	controller := http.NewResponseController(w)
+	if err := controller.SetReadDeadline(deadline); err != nil {
+		rt.baseLogger.WithError(err).Debug("cannot extend the read deadline")
+	}
+	if err := controller.SetWriteDeadline(deadline); err != nil {
+		rt.baseLogger.WithError(err).Debug("cannot extend the write deadline")
+	}
+}
+
+// readMultipartFile reads the whole content of an uploaded multipart file
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// sanitizeFileName strips any path components and control characters from an uploaded file name
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	// Truncate to 255 bytes without splitting a character
+	if len(name) > 255 {
+		cut := 255
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	return name
+}
+
+// detectMimeType returns the declared MIME type of an upload, sniffing the content when none is given
+func detectMimeType(declared string, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	return http.DetectContentType(data)
+}
+
+// writeImage writes an image
+func (rt *_router) writeImage(w http.ResponseWriter, photo []byte) {
+	w.Header().Set("Content-Type", http.DetectContentType(photo))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(photo); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing photo")
+	}
+}
+
+// writeThumbnail writes the thumbnail of a photo, creating it on first use and caching
+// it with store. Photos we can't scale down are served as they are.
+func (rt *_router) writeThumbnail(w http.ResponseWriter, photo, cached []byte, store func([]byte) error) {
+	if cached == nil {
+		thumb, err := media.Thumbnail(photo, media.ThumbnailSize)
+		if err != nil {
+			rt.baseLogger.WithError(err).Debug("cannot create thumbnail")
+			rt.writeImage(w, photo)
+			return
+		}
+		if err := store(thumb); err != nil {
+			rt.baseLogger.WithError(err).Error("error caching thumbnail")
+		}
+		cached = thumb
+	}
+	rt.writeImage(w, cached)
+}
+
+// getMessagePhoto returns the photo of a photo message
+func (rt *_router) getMessagePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypePhoto)
+	if msg == nil {
+		return
+	}
+	rt.extendTransferDeadlines(w)
+	rt.writeImage(w, msg.Photo)
+}
+
+// getMessageThumbnail returns a scaled down copy of the photo of a photo message
+func (rt *_router) getMessageThumbnail(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypePhoto)
+	if msg == nil {
+		return
+	}
+	rt.writeThumbnail(w, msg.Photo, msg.Thumbnail, func(thumb []byte) error {
+		return rt.db.SetMessageThumbnail(msg.ID, thumb)
+	})
+}
+
+// getMessageFile downloads the attachment of a file message
+func (rt *_router) getMessageFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypeFile)
+	if msg == nil {
+		return
+	}
+	rt.extendTransferDeadlines(w)
+
+	w.Header().Set("Content-Type", msg.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(msg.FileData)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": msg.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(msg.FileData); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing file")
+	}
+}
+
+// getMessageAudio streams a voice note, honoring Range requests so clients can seek
+func (rt *_router) getMessageAudio(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypeAudio)
+	if msg == nil {
+		return
+	}
+	rt.extendTransferDeadlines(w)
+
+	w.Header().Set("Content-Type", msg.MimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(msg.FileData))
+}
+
+// getAlbumItem loads the album photo at the position given in the path, checking
+// that the user can see the message without loading the rest of the album. On failure
+// it writes the error response and returns nil.
+func (rt *_router) getAlbumItem(w http.ResponseWriter, ps httprouter.Params, userID string) *database.MediaItem {
+	messageID := ps.ByName("messageId")
+
+	position, err := strconv.Atoi(ps.ByName("position"))
+	if err != nil || position < 0 {
+		http.Error(w, "Invalid position", http.StatusBadRequest)
+		return nil
+	}
+
+	conversationID, msgType, err := rt.db.GetMessageType(messageID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if msgType != MessageTypeAlbum {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil
+	}
+
+	// Check if user is a member of the conversation
+	isMember, err := rt.db.IsConversationMember(conversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	item, err := rt.db.GetMessageMediaItem(messageID, position)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting media item")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if item == nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return nil
+	}
+	rt.extendTransferDeadlines(w)
+	return item
+}
+
+// getMessageMedia returns one photo of an album message
+func (rt *_router) getMessageMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	item := rt.getAlbumItem(w, ps, ctx.UserID)
+	if item == nil {
+		return
+	}
+	rt.writeImage(w, item.Photo)
+}
+
+// getMessageMediaThumbnail returns a scaled down copy of one photo of an album message
+func (rt *_router) getMessageMediaThumbnail(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	item := rt.getAlbumItem(w, ps, ctx.UserID)
+	if item == nil {
+		return
+	}
+	messageID := ps.ByName("messageId")
+	rt.writeThumbnail(w, item.Photo, item.Thumbnail, func(thumb []byte) error {
+		return rt.db.SetMessageMediaThumbnail(messageID, item.Position, thumb)
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"unix path", "../../etc/passwd", "passwd"},
+		{"windows path", `C:\Users\me\report.pdf`, "report.pdf"},
+		{"control characters", "re\x00po\nrt.pdf", "report.pdf"},
+		{"empty", "", "file"},
+		{"dot", ".", "file"},
+		{"only a separator", "/", "file"},
+		{"long ascii", strings.Repeat("a", 300), strings.Repeat("a", 255)},
+		// 127 two-byte characters fill 254 bytes, the next one would end past 255
+		{"long two-byte", strings.Repeat("é", 200), strings.Repeat("é", 127)},
+		{"long four-byte", "a" + strings.Repeat("😀", 100), "a" + strings.Repeat("😀", 63)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFileName(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeFileName = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > 255 {
+				t.Errorf("sanitizeFileName = %q, not valid UTF-8 of at most 255 bytes", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type forwardMessageRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type forwardMessagesRequest struct {
+	MessageIDs      []string `json:"messageIds"`
+	ConversationIDs []string `json:"conversationIds"`
+}
+
+type forwardMessagesResponse struct {
+	Messages []messageResponse `json:"messages"`
+}
+
+type forwardedFromResponse struct {
+	UserID         string `json:"userId"`
+	Username       string `json:"username,omitempty"`
+	ConversationID string `json:"conversationId"`
+}
+
+// newForward copies a message into a conversation as sent by userID. The copy is
+// attributed to the author of the first message in a chain of forwards.
+func newForward(original *database.Message, conversationID, userID string) *database.Message {
+	msg := &database.Message{
+		ID:             uuid.New().String(),
+		ConversationID: conversationID,
+		SenderID:       userID,
+		Content:        original.Content,
+		Photo:          original.Photo,
+		Caption:        original.Caption,
+		AltText:        original.AltText,
+		FileData:       original.FileData,
+		FileName:       original.FileName,
+		MimeType:       original.MimeType,
+		FileSize:       original.FileSize,
+		DurationMs:     original.DurationMs,
+		Media:          original.Media,
+		Poll:           original.Poll,
+		ContactUserID:  original.ContactUserID,
+		Type:           original.Type,
+		Forwarded:      true,
+		ForwardedFrom:  original.ForwardedFrom,
+	}
+	if msg.ForwardedFrom == nil {
+		msg.ForwardedFrom = &database.ForwardOrigin{
+			MessageID:      original.ID,
+			UserID:         original.SenderID,
+			ConversationID: original.ConversationID,
+		}
+	}
+
+	// A forwarded live location is a snapshot of its latest point
+	if original.Location != nil {
+		location := *original.Location
+		location.LiveUntil = ""
+		location.Live = false
+		msg.Location = &location
+	}
+
+	return msg
+}
+
+// setForwardInfo fills the forward attribution and count of a message response,
+// unless the original author does not want forwards of their messages to name them
+func (rt *_router) setForwardInfo(resp *messageResponse, msg *database.Message) {
+	authorID := msg.SenderID
+	if msg.ForwardedFrom != nil {
+		authorID = msg.ForwardedFrom.UserID
+	}
+
+	settings, err := rt.db.GetPrivacySettings(authorID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		return
+	}
+	if settings.ForwardAttribution == database.AudienceNobody {
+		return
+	}
+
+	resp.ForwardCount = msg.ForwardCount
+	if msg.ForwardedFrom != nil {
+		resp.ForwardedFrom = &forwardedFromResponse{
+			UserID:         msg.ForwardedFrom.UserID,
+			ConversationID: msg.ForwardedFrom.ConversationID,
+		}
+		resp.ForwardedFrom.Username = deletedUsername
+		if author, _ := rt.db.GetUserByID(authorID); author != nil {
+			resp.ForwardedFrom.Username = author.Username
+		}
+	}
+}
+
+// newSenderResponse loads a stored message and builds its response as seen by its sender
+func (rt *_router) newSenderResponse(messageID string) (*messageResponse, error) {
+	msg, err := rt.db.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, errors.New("message not found")
+	}
+	sender, err := rt.db.GetUserByID(msg.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	senderUsername := deletedUsername
+	senderBot := false
+	if sender != nil {
+		senderUsername = sender.Username
+		senderBot = sender.Bot
+	}
+
+	response := messageResponse{
+		ID:             msg.ID,
+		SenderID:       msg.SenderID,
+		SenderUsername: senderUsername,
+		Bot:            senderBot,
+		Type:           msg.Type,
+		Timestamp:      msg.CreatedAt,
+		Checkmarks:     1,
+		Forwarded:      msg.Forwarded,
+		Comments:       []commentResponse{},
+	}
+	setMessageContent(&response, msg, msg.SenderID)
+	rt.setMessageDetails(&response, msg, msg.SenderID)
+	return &response, nil
+}
+
+// getForwardableMessage loads a message the user can see, or writes a 404 and returns nil
+func (rt *_router) getForwardableMessage(w http.ResponseWriter, messageID, userID string) *database.Message {
+	msg, err := rt.db.GetMessage(messageID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if msg == nil {
+		http.Error(w, "Original message not found", http.StatusNotFound)
+		return nil
+	}
+
+	// Check if user can access the original message (is member of its conversation)
+	isMember, err := rt.db.IsConversationMember(msg.ConversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		http.Error(w, "Original message not found", http.StatusNotFound)
+		return nil
+	}
+
+	return msg
+}
+
+// forwardMessage forwards a message to a conversation
+func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	// Check membership in target conversation
+	isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !rt.checkCanSend(w, conversationID, ctx.UserID) {
+		return
+	}
+
+	var req forwardMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	originalMsg := rt.getForwardableMessage(w, req.MessageID, ctx.UserID)
+	if originalMsg == nil {
+		return
+	}
+
+	newMsg := newForward(originalMsg, conversationID, ctx.UserID)
+
+	if size := messageSize(newMsg); size > 0 && !rt.checkQuota(w, ctx.UserID, conversationID, size) {
+		return
+	}
+
+	if err := rt.db.CreateMessage(newMsg); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := rt.newSenderResponse(newMsg.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publish(conversationID, webhook.EventMessageCreated, response)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// forwardMessages forwards several messages to several conversations at once.
+// Either every copy is created or, on any error, none is.
+func (rt *_router) forwardMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var req forwardMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.MessageIDs) == 0 || len(req.MessageIDs) > maxForwardMessages {
+		http.Error(w, "Forward 1-20 messages at a time", http.StatusBadRequest)
+		return
+	}
+	if len(req.ConversationIDs) == 0 || len(req.ConversationIDs) > maxForwardTargets {
+		http.Error(w, "Forward to 1-10 conversations at a time", http.StatusBadRequest)
+		return
+	}
+	if hasDuplicates(req.MessageIDs) || hasDuplicates(req.ConversationIDs) {
+		http.Error(w, "Duplicate message or conversation", http.StatusBadRequest)
+		return
+	}
+
+	// Check membership in every target conversation
+	for _, conversationID := range req.ConversationIDs {
+		isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error checking membership")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !isMember {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !rt.checkCanSend(w, conversationID, ctx.UserID) {
+			return
+		}
+	}
+
+	originals := make([]*database.Message, len(req.MessageIDs))
+	var size int64
+	for i, messageID := range req.MessageIDs {
+		originals[i] = rt.getForwardableMessage(w, messageID, ctx.UserID)
+		if originals[i] == nil {
+			return
+		}
+		size += messageSize(originals[i])
+	}
+
+	// Every target receives a copy of every message
+	if size > 0 {
+		if !rt.checkQuota(w, ctx.UserID, "", size*int64(len(req.ConversationIDs))) {
+			return
+		}
+		for _, conversationID := range req.ConversationIDs {
+			if !rt.checkQuota(w, "", conversationID, size) {
+				return
+			}
+		}
+	}
+
+	var forwards []*database.Message
+	for _, conversationID := range req.ConversationIDs {
+		for _, original := range originals {
+			forwards = append(forwards, newForward(original, conversationID, ctx.UserID))
+		}
+	}
+
+	if err := rt.db.CreateMessages(forwards); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating messages")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := forwardMessagesResponse{Messages: make([]messageResponse, len(forwards))}
+	for i, msg := range forwards {
+		created, err := rt.newSenderResponse(msg.ID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting message")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		response.Messages[i] = *created
+	}
+	for i, msg := range forwards {
+		rt.publish(msg.ConversationID, webhook.EventMessageCreated, response.Messages[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// hasDuplicates reports whether any ID appears more than once
+func hasDuplicates(ids []string) bool {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return true
+		}
+		seen[id] = true
+	}
+	return false
+}
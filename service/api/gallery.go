@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+)
+
+// galleryPageSize is the number of media messages returned per page
+const galleryPageSize = 50
+
+// galleryTypes maps the gallery type filter to the message types it covers
+var galleryTypes = map[string][]string{
+	"":               {MessageTypePhoto, MessageTypeAlbum, MessageTypeFile, MessageTypeAudio},
+	MessageTypePhoto: {MessageTypePhoto, MessageTypeAlbum},
+	MessageTypeFile:  {MessageTypeFile},
+	MessageTypeAudio: {MessageTypeAudio},
+}
+
+type mediaItemResponse struct {
+	MessageID      string              `json:"messageId"`
+	Type           string              `json:"type"`
+	SenderID       string              `json:"senderId"`
+	SenderUsername string              `json:"senderUsername"`
+	Timestamp      string              `json:"timestamp"`
+	URL            string              `json:"url"`
+	ThumbnailURL   string              `json:"thumbnailUrl,omitempty"`
+	Caption        string              `json:"caption,omitempty"`
+	AltText        string              `json:"altText,omitempty"`
+	File           *fileResponse       `json:"file,omitempty"`
+	DurationMs     int64               `json:"durationMs,omitempty"`
+	Album          []albumItemResponse `json:"album,omitempty"`
+}
+
+type mediaGalleryResponse struct {
+	Items      []mediaItemResponse `json:"items"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// getConversationMedia lists the media shared in a conversation, newest first
+func (rt *_router) getConversationMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	// Check if user is a member
+	isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	types, ok := galleryTypes[r.URL.Query().Get("type")]
+	if !ok {
+		http.Error(w, "Type must be photo, file or audio", http.StatusBadRequest)
+		return
+	}
+	cursor := r.URL.Query().Get("cursor")
+
+	// Fetch one extra message to know whether there is a next page
+	messages, err := rt.db.GetConversationMedia(conversationID, types, cursor, galleryPageSize+1)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting media")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := mediaGalleryResponse{Items: []mediaItemResponse{}}
+	if len(messages) > galleryPageSize {
+		messages = messages[:galleryPageSize]
+		response.NextCursor = messages[galleryPageSize-1].ID
+	}
+
+	usernames := make(map[string]string)
+	for i := range messages {
+		msg := &messages[i]
+
+		if _, ok := usernames[msg.SenderID]; !ok {
+			sender, err := rt.db.GetUserByID(msg.SenderID)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error getting sender")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			usernames[msg.SenderID] = deletedUsername
+			if sender != nil {
+				usernames[msg.SenderID] = sender.Username
+			}
+		}
+
+		var content messageResponse
+		setMessageContent(&content, msg, ctx.UserID)
+
+		item := mediaItemResponse{
+			MessageID:      msg.ID,
+			Type:           msg.Type,
+			SenderID:       msg.SenderID,
+			SenderUsername: usernames[msg.SenderID],
+			Timestamp:      msg.CreatedAt,
+			URL:            content.Content,
+			Caption:        content.Caption,
+			AltText:        content.AltText,
+			File:           content.File,
+			DurationMs:     content.DurationMs,
+			Album:          content.Album,
+		}
+		switch msg.Type {
+		case MessageTypePhoto:
+			item.ThumbnailURL = "/messages/" + msg.ID + "/thumbnail"
+		case MessageTypeAlbum:
+			if len(content.Album) > 0 {
+				item.ThumbnailURL = content.Album[0].ThumbnailURL
+			}
+		}
+
+		response.Items = append(response.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
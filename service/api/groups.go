@@ -2,12 +2,16 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
 )
 
 type createGroupRequest struct {
@@ -48,6 +52,7 @@ func (rt *_router) createGroup(w http.ResponseWriter, r *http.Request, ps httpro
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	rt.audit(r, ctx.UserID, audit.ActionGroupCreated, groupID, audit.Details{"name": req.Name})
 
 	// Get creator info
 	creator, _ := rt.db.GetUserByID(ctx.UserID)
@@ -118,11 +123,43 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
+	// Users who blocked the requester cannot be added by them
+	blocked, err := rt.db.IsBlocked(req.UserID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking blocks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if blocked {
+		http.Error(w, "Forbidden - blocked by this user", http.StatusForbidden)
+		return
+	}
+
+	// The target decides who can add them to groups
+	settings, err := rt.db.GetPrivacySettings(req.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	allowed, err := rt.inAudience(settings.GroupAdd, req.UserID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking group add setting")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden - this user does not allow you to add them to groups", http.StatusForbidden)
+		return
+	}
+
 	if err := rt.db.AddGroupMember(groupID, req.UserID); err != nil {
 		rt.baseLogger.WithError(err).Error("error adding member")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	rt.publishMember(groupID, webhook.EventMemberAdded, req.UserID, ctx.UserID)
+	rt.audit(r, ctx.UserID, audit.ActionMemberAdded, groupID, audit.Details{"user": req.UserID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -155,6 +192,8 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Not a member", http.StatusNotFound)
 		return
 	}
+	rt.publishMember(groupID, webhook.EventMemberRemoved, userID, ctx.UserID)
+	rt.audit(r, ctx.UserID, audit.ActionMemberRemoved, groupID, audit.Details{"user": userID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -249,6 +288,11 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
+	// The new photo replaces the old one in the group's quota
+	if !rt.checkQuota(w, "", groupID, int64(len(photo)-len(conv.Photo))) {
+		return
+	}
+
 	if err := rt.db.UpdateGroupPhoto(groupID, photo); err != nil {
 		rt.baseLogger.WithError(err).Error("error updating group photo")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -257,3 +301,84 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// getGroupMember loads a member of a group for a group admin acting on their role, or
+// writes the error response and returns nil
+func (rt *_router) getGroupMember(w http.ResponseWriter, groupID, userID string) *database.User {
+	isMember, err := rt.db.IsConversationMember(groupID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		http.Error(w, "Not a member", http.StatusNotFound)
+		return nil
+	}
+
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		http.Error(w, "Not a member", http.StatusNotFound)
+		return nil
+	}
+	return user
+}
+
+// addGroupAdmin makes a member an admin of the group
+func (rt *_router) addGroupAdmin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+
+	user := rt.getGroupMember(w, groupID, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+	if user.Bot {
+		http.Error(w, "Bots can't be group admins", http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.SetGroupAdmin(groupID, user.ID, true); err != nil {
+		rt.baseLogger.WithError(err).Error("error granting group admin role")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionGroupAdminGrant, groupID, audit.Details{"user": user.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeGroupAdmin takes the admin role of the group away from a member. Admins can
+// step down too, as long as another admin is left.
+func (rt *_router) removeGroupAdmin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+
+	user := rt.getGroupMember(w, groupID, ps.ByName("userId"))
+	if user == nil {
+		return
+	}
+
+	err := rt.db.SetGroupAdmin(groupID, user.ID, false)
+	if errors.Is(err, database.ErrLastGroupAdmin) {
+		http.Error(w, "A group needs at least one admin", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error revoking group admin role")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionGroupAdminRevoke, groupID, audit.Details{"user": user.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type incomingWebhookRequest struct {
+	Name    string `json:"name"`
+	Enabled *bool  `json:"enabled"`
+}
+
+type incomingWebhookResponse struct {
+	ID         string `json:"id"`
+	GroupID    string `json:"groupId"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	Token      string `json:"token,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Enabled    bool   `json:"enabled"`
+	CreatedBy  string `json:"createdBy"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+type incomingMessageRequest struct {
+	Text string `json:"text"`
+}
+
+// newIncomingWebhookToken returns a random token and the prefix shown when listing hooks
+func newIncomingWebhookToken() (token, prefix string, err error) {
+	b := make([]byte, incomingWebhookTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = incomingWebhookTokenPrefix + hex.EncodeToString(b)
+	return token, token[:apiKeyDisplayLength], nil
+}
+
+// incomingWebhookPath is the path a token posts messages to
+func incomingWebhookPath(token string) string {
+	return "/hooks/" + token
+}
+
+// validIncomingWebhookName reports whether name can be shown as the author of messages
+func validIncomingWebhookName(name string) bool {
+	return strings.TrimSpace(name) != "" && utf8.RuneCountInString(name) <= maxIncomingWebhookNameLength
+}
+
+// newIncomingWebhookResponse converts an incoming webhook to its API representation, without its token
+func newIncomingWebhookResponse(hook *database.IncomingWebhook) incomingWebhookResponse {
+	return incomingWebhookResponse{
+		ID:         hook.ID,
+		GroupID:    hook.ConversationID,
+		Name:       hook.Name,
+		Prefix:     hook.Prefix,
+		Enabled:    hook.Enabled,
+		CreatedBy:  hook.CreatedBy,
+		CreatedAt:  hook.CreatedAt,
+		LastUsedAt: hook.LastUsedAt,
+	}
+}
+
+// checkGroupAdmin checks that a group exists and that the user administers it. On
+// failure it writes an error and returns false.
+func (rt *_router) checkGroupAdmin(w http.ResponseWriter, groupID, userID string) bool {
+	conv, err := rt.db.GetConversation(groupID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting group")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if conv == nil || conv.Type != ConversationTypeGroup {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return false
+	}
+
+	isAdmin, err := rt.db.IsGroupAdmin(groupID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking group admin")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !isAdmin {
+		http.Error(w, "Forbidden - only group admins can do this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// getGroupIncomingWebhook loads an incoming webhook of a group, or writes a 404 and returns nil
+func (rt *_router) getGroupIncomingWebhook(w http.ResponseWriter, groupID, hookID string) *database.IncomingWebhook {
+	hook, err := rt.db.GetIncomingWebhook(hookID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if hook == nil || hook.ConversationID != groupID {
+		http.Error(w, "Incoming webhook not found", http.StatusNotFound)
+		return nil
+	}
+	return hook
+}
+
+// getIncomingWebhooks lists the incoming webhooks of a group
+func (rt *_router) getIncomingWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+
+	hooks, err := rt.db.GetIncomingWebhooks(groupID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting incoming webhooks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]incomingWebhookResponse, len(hooks))
+	for i := range hooks {
+		response[i] = newIncomingWebhookResponse(&hooks[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// createIncomingWebhook creates an incoming webhook for a group. Its token is only returned here.
+func (rt *_router) createIncomingWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+
+	var req incomingWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validIncomingWebhookName(req.Name) {
+		http.Error(w, "Name must be 1-32 characters", http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := rt.db.GetIncomingWebhooks(groupID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting incoming webhooks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(hooks) >= maxIncomingWebhooksPerGroup {
+		http.Error(w, "Too many incoming webhooks for this group", http.StatusConflict)
+		return
+	}
+
+	token, prefix, err := newIncomingWebhookToken()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating incoming webhook token")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hook := database.IncomingWebhook{
+		ID:             uuid.New().String(),
+		ConversationID: groupID,
+		CreatedBy:      ctx.UserID,
+		Name:           req.Name,
+		Prefix:         prefix,
+	}
+	if err := rt.db.CreateIncomingWebhook(&hook, hashToken(token)); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	created, err := rt.db.GetIncomingWebhook(hook.ID)
+	if err != nil || created == nil {
+		rt.baseLogger.WithError(err).Error("error getting incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response := newIncomingWebhookResponse(created)
+	response.Token = token
+	response.Path = incomingWebhookPath(token)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// updateIncomingWebhook renames an incoming webhook, or enables or disables it
+func (rt *_router) updateIncomingWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+	hook := rt.getGroupIncomingWebhook(w, groupID, ps.ByName("hookId"))
+	if hook == nil {
+		return
+	}
+
+	var req incomingWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name != "" {
+		if !validIncomingWebhookName(req.Name) {
+			http.Error(w, "Name must be 1-32 characters", http.StatusBadRequest)
+			return
+		}
+		hook.Name = req.Name
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+
+	if err := rt.db.UpdateIncomingWebhook(hook.ID, hook.Name, hook.Enabled); err != nil {
+		rt.baseLogger.WithError(err).Error("error updating incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newIncomingWebhookResponse(hook)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// rotateIncomingWebhookToken gives an incoming webhook a new token. The old URL stops working.
+func (rt *_router) rotateIncomingWebhookToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+	hook := rt.getGroupIncomingWebhook(w, groupID, ps.ByName("hookId"))
+	if hook == nil {
+		return
+	}
+
+	token, prefix, err := newIncomingWebhookToken()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating incoming webhook token")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.RotateIncomingWebhookToken(hook.ID, prefix, hashToken(token)); err != nil {
+		rt.baseLogger.WithError(err).Error("error rotating incoming webhook token")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hook.Prefix = prefix
+	response := newIncomingWebhookResponse(hook)
+	response.Token = token
+	response.Path = incomingWebhookPath(token)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// deleteIncomingWebhook removes an incoming webhook, keeping the messages it posted
+func (rt *_router) deleteIncomingWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID := ps.ByName("groupId")
+
+	if !rt.checkGroupAdmin(w, groupID, ctx.UserID) {
+		return
+	}
+	hook := rt.getGroupIncomingWebhook(w, groupID, ps.ByName("hookId"))
+	if hook == nil {
+		return
+	}
+
+	if err := rt.db.DeleteIncomingWebhook(hook.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionIncomingWebhookDeleted, hook.ID, audit.Details{"group": groupID, "name": hook.Name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readIncomingMessage reads the text of a message posted to an incoming webhook, sent
+// either as plain text or as JSON with a text field. A body past the limit of the
+// request fails with *http.MaxBytesError.
+func readIncomingMessage(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.New("Error reading request body")
+	}
+
+	text := string(body)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var req incomingMessageRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", errors.New("Invalid request body")
+		}
+		text = req.Text
+	case "text/plain", "":
+	default:
+		return "", errors.New("Content type must be application/json or text/plain")
+	}
+
+	if !utf8.ValidString(text) || strings.TrimSpace(text) == "" || utf8.RuneCountInString(text) > maxIncomingWebhookTextLength {
+		return "", errors.New("Text must be 1-4096 characters")
+	}
+	return text, nil
+}
+
+// postIncomingMessage creates a text message in the group of the incoming webhook
+// whose token is in the URL. It needs no other authentication.
+func (rt *_router) postIncomingMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hook, err := rt.db.GetIncomingWebhookByTokenHash(hashToken(ps.ByName("token")))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting incoming webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if hook == nil {
+		http.Error(w, "Incoming webhook not found", http.StatusNotFound)
+		return
+	}
+	if !hook.Enabled {
+		http.Error(w, "Incoming webhook disabled", http.StatusForbidden)
+		return
+	}
+	if !rt.allow(w, RateLimitIncomingWebhook, hook.ID) {
+		return
+	}
+
+	// Messages are sent on behalf of the admin who created the hook
+	if _, ok := rt.activeUser(w, hook.CreatedBy); !ok {
+		return
+	}
+	isAdmin, err := rt.db.IsGroupAdmin(hook.ConversationID, hook.CreatedBy)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking group admin")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isAdmin {
+		http.Error(w, "Forbidden - the creator of this webhook is no longer a group admin", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxIncomingWebhookRequestBytes)
+	text, err := readIncomingMessage(r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg := database.Message{
+		ID:             uuid.New().String(),
+		ConversationID: hook.ConversationID,
+		SenderID:       hook.CreatedBy,
+		Content:        text,
+		Type:           MessageTypeText,
+		Integration:    hook.Name,
+	}
+	if err := rt.db.CreateMessage(&msg); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.MarkIncomingWebhookUsed(hook.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error marking incoming webhook used")
+	}
+
+	response, err := rt.newSenderResponse(msg.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publish(hook.ConversationID, webhook.EventMessageCreated, response)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type locationRequest struct {
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	Accuracy    float64  `json:"accuracy"`
+	Label       string   `json:"label,omitempty"`
+	LiveSeconds int      `json:"liveSeconds,omitempty"`
+}
+
+type locationResponse struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
+	Label     string  `json:"label,omitempty"`
+	Live      bool    `json:"live"`
+	LiveUntil string  `json:"liveUntil,omitempty"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+// validatePoint checks that coordinates and accuracy describe a point on Earth
+func validatePoint(latitude, longitude *float64, accuracy float64) error {
+	if latitude == nil || longitude == nil {
+		return errors.New("Latitude and longitude are required")
+	}
+	if *latitude < -90 || *latitude > 90 {
+		return errors.New("Latitude must be between -90 and 90")
+	}
+	if *longitude < -180 || *longitude > 180 {
+		return errors.New("Longitude must be between -180 and 180")
+	}
+	if accuracy < 0 || accuracy > maxLocationAccuracy {
+		return errors.New("Accuracy must be between 0 and 100000 meters")
+	}
+	return nil
+}
+
+// newLocation validates a location request and converts it to a location to store
+func newLocation(req *locationRequest) (*database.Location, error) {
+	if req == nil {
+		return nil, errors.New("Location is required")
+	}
+	if err := validatePoint(req.Latitude, req.Longitude, req.Accuracy); err != nil {
+		return nil, err
+	}
+	if len(req.Label) > maxLocationLabelLength {
+		return nil, errors.New("Location label must be at most 100 characters")
+	}
+
+	location := &database.Location{
+		Latitude:  *req.Latitude,
+		Longitude: *req.Longitude,
+		Accuracy:  req.Accuracy,
+		Label:     req.Label,
+	}
+
+	if req.LiveSeconds != 0 {
+		duration := time.Duration(req.LiveSeconds) * time.Second
+		if duration < minLiveLocationDuration || duration > maxLiveLocationDuration {
+			return nil, errors.New("Live location must last between 1 minute and 8 hours")
+		}
+		location.LiveUntil = time.Now().Add(duration).UTC().Format(time.RFC3339)
+		location.Live = true
+	}
+
+	return location, nil
+}
+
+// newLocationResponse converts a stored location to its API representation
+func newLocationResponse(location *database.Location) *locationResponse {
+	return &locationResponse{
+		Latitude:  location.Latitude,
+		Longitude: location.Longitude,
+		Accuracy:  location.Accuracy,
+		Label:     location.Label,
+		Live:      location.Live,
+		LiveUntil: location.LiveUntil,
+		UpdatedAt: location.UpdatedAt,
+	}
+}
+
+// getLocation returns the latest point of a location message
+func (rt *_router) getLocation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypeLocation)
+	if msg == nil {
+		return
+	}
+	if msg.Location == nil {
+		http.Error(w, "Location not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newLocationResponse(msg.Location)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// updateLiveLocation lets the sender move a live location until it ends
+func (rt *_router) updateLiveLocation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypeLocation)
+	if msg == nil {
+		return
+	}
+	if msg.Location == nil {
+		http.Error(w, "Location not found", http.StatusNotFound)
+		return
+	}
+
+	// Only the sender can move the location
+	if msg.SenderID != ctx.UserID {
+		http.Error(w, "Forbidden - only the sender can update the location", http.StatusForbidden)
+		return
+	}
+	if !msg.Location.Live {
+		http.Error(w, "Live location has ended", http.StatusConflict)
+		return
+	}
+
+	var req locationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validatePoint(req.Latitude, req.Longitude, req.Accuracy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The location may have ended since it was loaded
+	err := rt.db.UpdateLiveLocation(msg.ID, *req.Latitude, *req.Longitude, req.Accuracy)
+	if errors.Is(err, database.ErrLiveLocationEnded) {
+		http.Error(w, "Live location has ended", http.StatusConflict)
+		return
+	} else if err != nil {
+		rt.baseLogger.WithError(err).Error("error updating live location")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishMessage(msg.ConversationID, webhook.EventMessageUpdated, msg.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stopLiveLocation lets the sender end a live location before its duration
+func (rt *_router) stopLiveLocation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypeLocation)
+	if msg == nil {
+		return
+	}
+
+	// Only the sender can stop sharing
+	if msg.SenderID != ctx.UserID {
+		http.Error(w, "Forbidden - only the sender can stop sharing", http.StatusForbidden)
+		return
+	}
+
+	if err := rt.db.StopLiveLocation(msg.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error stopping live location")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishMessage(msg.ConversationID, webhook.EventMessageUpdated, msg.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,22 +4,31 @@ import (
 	"encoding/json"
 	"net/http"
 	"regexp"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type loginRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
 }
 
 type loginResponse struct {
 	Identifier string `json:"identifier"`
+	Token      string `json:"token"`
 }
 
 var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]{3,16}$`)
 
-// doLogin handles user login/registration
+// doLogin handles user login/registration. Users who set a password must provide it,
+// new users may set one right away. Users with two-factor authentication also provide
+// a code from their authenticator or a recovery code.
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var req loginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -42,9 +51,63 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 
 	var identifier string
 	if user != nil {
-		// User exists, return their identifier
+		if user.Bot {
+			http.Error(w, "Bots authenticate with API keys", http.StatusForbidden)
+			return
+		}
+
+		// User exists, check their password if they have one
 		identifier = user.ID
+		stored, err := rt.db.GetPassword(identifier)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting password")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if stored == nil && user.Admin {
+			http.Error(w, "Admins must log in with a password", http.StatusForbidden)
+			return
+		}
+		if stored == nil {
+			// Without a password, single sign-on is the only way in
+			linked, err := rt.db.HasOIDCIdentity(identifier)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error checking single sign-on identity")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if linked {
+				http.Error(w, "Log in with single sign-on", http.StatusForbidden)
+				return
+			}
+		}
+		if stored != nil && !rt.checkPassword(w, identifier, stored, req.Password, http.StatusUnauthorized) {
+			rt.audit(r, "", audit.ActionLoginFailed, identifier, audit.Details{"reason": "password"})
+			return
+		}
+
+		secondFactor, err := rt.db.GetTOTP(identifier)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if secondFactor != nil && secondFactor.Enabled && !rt.checkSecondFactor(w, identifier, secondFactor, req.OTP, http.StatusUnauthorized) {
+			rt.audit(r, "", audit.ActionLoginFailed, identifier, audit.Details{"reason": "second_factor"})
+			return
+		}
+		rt.resetLoginFailures(identifier, stored)
+		if user.SuspendedAt != "" {
+			rt.audit(r, "", audit.ActionLoginFailed, identifier, audit.Details{"reason": "suspended"})
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
 	} else {
+		if req.Password != "" && !validPassword(req.Password) {
+			http.Error(w, "Password must be 8-72 bytes", http.StatusBadRequest)
+			return
+		}
+
 		// Create new user
 		identifier = uuid.New().String()
 		err = rt.db.CreateUser(identifier, req.Name)
@@ -53,11 +116,68 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+
+		if req.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error hashing password")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := rt.db.SetPassword(identifier, string(hash)); err != nil {
+				rt.baseLogger.WithError(err).Error("error setting password")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	token, err := rt.newSession(identifier)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error creating session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
+	rt.audit(r, identifier, audit.ActionLogin, identifier, audit.Details{"method": "username", "registered": strconv.FormatBool(user == nil)})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(loginResponse{Identifier: identifier}); err != nil {
+	if err := json.NewEncoder(w).Encode(loginResponse{Identifier: identifier, Token: token}); err != nil {
 		rt.baseLogger.WithError(err).Error("error encoding response")
 	}
 }
+
+// doLogout ends the session the request was made with
+func (rt *_router) doLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if ctx.TokenHash == "" {
+		http.Error(w, "Not logged in with a session", http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.DeleteSession(ctx.TokenHash); err != nil {
+		rt.baseLogger.WithError(err).Error("error ending session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionSessionsRevoked, ctx.UserID, audit.Details{"reason": "logout"})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endOtherSessions logs a user out everywhere but from the session the request was made with
+func (rt *_router) endOtherSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := rt.db.DeleteUserSessions(userID, ctx.TokenHash); err != nil {
+		rt.baseLogger.WithError(err).Error("error ending sessions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionSessionsRevoked, userID, audit.Details{"reason": "logout_others"})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,26 +2,57 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
 	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/media"
+	"github.com/sapienzaapps/wasatext/service/webhook"
 )
 
 type messageResponse struct {
 	ID             string                  `json:"id"`
 	SenderID       string                  `json:"senderId"`
 	SenderUsername string                  `json:"senderUsername"`
+	Integration    string                  `json:"integration,omitempty"`
+	Bot            bool                    `json:"bot"`
 	Type           string                  `json:"type"`
 	Content        string                  `json:"content"`
+	Caption        string                  `json:"caption,omitempty"`
+	AltText        string                  `json:"altText,omitempty"`
+	File           *fileResponse           `json:"file,omitempty"`
+	Album          []albumItemResponse     `json:"album,omitempty"`
+	Poll           *pollResponse           `json:"poll,omitempty"`
+	Location       *locationResponse       `json:"location,omitempty"`
+	Contact        *userResponse           `json:"contact,omitempty"`
+	ForwardedFrom  *forwardedFromResponse  `json:"forwardedFrom,omitempty"`
+	ForwardCount   int                     `json:"forwardCount,omitempty"`
+	DurationMs     int64                   `json:"durationMs,omitempty"`
 	Timestamp      string                  `json:"timestamp"`
 	Checkmarks     int                     `json:"checkmarks"`
 	ReplyTo        *messagePreviewResponse `json:"replyTo,omitempty"`
 	Forwarded      bool                    `json:"forwarded"`
 	Comments       []commentResponse       `json:"comments"`
+	Buttons        [][]buttonResponse      `json:"buttons,omitempty"`
+}
+
+type fileResponse struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+}
+
+type albumItemResponse struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Caption      string `json:"caption,omitempty"`
+	AltText      string `json:"altText,omitempty"`
 }
 
 type commentResponse struct {
@@ -31,13 +62,14 @@ type commentResponse struct {
 }
 
 type sendMessageRequest struct {
-	Type      string `json:"type"`
-	Content   string `json:"content"`
-	ReplyToID string `json:"replyToId,omitempty"`
-}
-
-type forwardMessageRequest struct {
-	MessageID string `json:"messageId"`
+	Type          string           `json:"type"`
+	Content       string           `json:"content"`
+	Caption       string           `json:"caption,omitempty"`
+	AltText       string           `json:"altText,omitempty"`
+	Poll          *pollRequest     `json:"poll,omitempty"`
+	Location      *locationRequest `json:"location,omitempty"`
+	ContactUserID string           `json:"contactUserId,omitempty"`
+	ReplyToID     string           `json:"replyToId,omitempty"`
 }
 
 // sendMessage sends a message to a conversation
@@ -55,6 +87,9 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	if !rt.checkCanSend(w, conversationID, ctx.UserID) {
+		return
+	}
 
 	contentType := r.Header.Get("Content-Type")
 
@@ -70,7 +105,9 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 			return
 		}
 
-		if req.Type != "text" && req.Type != "photo" {
+		switch req.Type {
+		case MessageTypeText, MessageTypePhoto, MessageTypePoll, MessageTypeLocation, MessageTypeContact:
+		default:
 			http.Error(w, "Invalid message type", http.StatusBadRequest)
 			return
 		}
@@ -78,156 +115,194 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		msg.Type = req.Type
 		msg.Content = req.Content
 		msg.ReplyToID = req.ReplyToID
+		switch req.Type {
+		case MessageTypePhoto:
+			msg.Caption = req.Caption
+			msg.AltText = req.AltText
+		case MessageTypePoll:
+			poll, err := newPoll(req.Poll)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			msg.Poll = poll
+			msg.Content = poll.Question
+		case MessageTypeLocation:
+			location, err := newLocation(req.Location)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			msg.Location = location
+			msg.Content = location.Label
+		case MessageTypeContact:
+			contact, err := rt.db.GetUserByID(req.ContactUserID)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error getting contact")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if contact == nil {
+				http.Error(w, "Contact not found", http.StatusBadRequest)
+				return
+			}
+			// Only the reference is stored, the profile is resolved when the message is read
+			msg.ContactUserID = contact.ID
+			msg.Content = ""
+		}
 	} else {
-		// Handle multipart form for photo upload
-		r.Body = http.MaxBytesReader(w, r.Body, 5*1024*1024)
+		// Handle multipart form for media uploads, which take longer than other requests
+		rt.extendTransferDeadlines(w)
+		r.Body = http.MaxBytesReader(w, r.Body, rt.maxUploadSize()+multipartOverhead)
 		if err := r.ParseMultipartForm(5 * 1024 * 1024); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Error parsing form", http.StatusBadRequest)
 			return
 		}
 
-		file, _, err := r.FormFile("photo")
-		if err != nil {
-			http.Error(w, "Error reading photo", http.StatusBadRequest)
-			return
+		msgType := r.FormValue("type")
+		if msgType == "" {
+			msgType = MessageTypePhoto
 		}
-		defer file.Close()
-
-		photo, err := io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "Error reading photo", http.StatusBadRequest)
+		if _, ok := rt.uploadLimits[msgType]; !ok {
+			http.Error(w, "Invalid message type", http.StatusBadRequest)
 			return
 		}
 
-		msg.Type = "photo"
-		msg.Photo = photo
+		msg.Type = msgType
+		if msgType == MessageTypeAlbum {
+			photos := r.MultipartForm.File["photos"]
+			if len(photos) < minAlbumItems || len(photos) > maxAlbumItems {
+				http.Error(w, "Albums must contain 2-10 photos", http.StatusBadRequest)
+				return
+			}
+			captions := r.MultipartForm.Value["captions"]
+			altTexts := r.MultipartForm.Value["altTexts"]
+
+			for i, header := range photos {
+				if header.Size > rt.uploadLimits[MessageTypePhoto] {
+					http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				photo, err := readMultipartFile(header)
+				if err != nil || len(photo) == 0 {
+					http.Error(w, "Error reading photos", http.StatusBadRequest)
+					return
+				}
+
+				item := database.MediaItem{Position: i, Photo: photo}
+				if i < len(captions) {
+					item.Caption = captions[i]
+				}
+				if i < len(altTexts) {
+					item.AltText = altTexts[i]
+				}
+				if len(item.Caption) > maxCaptionLength || len(item.AltText) > maxAltTextLength {
+					http.Error(w, "Caption or alt text too long", http.StatusBadRequest)
+					return
+				}
+				msg.Media = append(msg.Media, item)
+			}
+		} else {
+			file, header, err := r.FormFile(msgType)
+			if err != nil {
+				http.Error(w, "Error reading "+msgType, http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+
+			if header.Size > rt.uploadLimits[msgType] {
+				http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			data, err := io.ReadAll(file)
+			if err != nil {
+				http.Error(w, "Error reading "+msgType, http.StatusBadRequest)
+				return
+			}
+			if len(data) == 0 {
+				http.Error(w, "Empty "+msgType, http.StatusBadRequest)
+				return
+			}
+
+			switch msgType {
+			case MessageTypeFile:
+				msg.FileData = data
+				msg.FileName = sanitizeFileName(header.Filename)
+				msg.MimeType = detectMimeType(header.Header.Get("Content-Type"), data)
+				msg.FileSize = int64(len(data))
+			case MessageTypeAudio:
+				info, err := media.ParseAudio(data)
+				if errors.Is(err, media.ErrAudioTooLong) {
+					http.Error(w, "Audio must be at most 24 hours long", http.StatusBadRequest)
+					return
+				} else if err != nil {
+					http.Error(w, "Audio must be Ogg/Opus, WAV or M4A", http.StatusBadRequest)
+					return
+				}
+				msg.FileData = data
+				msg.MimeType = info.MimeType
+				msg.FileSize = int64(len(data))
+				msg.DurationMs = info.Duration.Milliseconds()
+			default:
+				msg.Photo = data
+				msg.Caption = r.FormValue("caption")
+				msg.AltText = r.FormValue("altText")
+			}
+		}
 		msg.ReplyToID = r.FormValue("replyToId")
 	}
 
-	if err := rt.db.CreateMessage(&msg); err != nil {
-		rt.baseLogger.WithError(err).Error("error creating message")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if len(msg.Caption) > maxCaptionLength {
+		http.Error(w, "Caption too long", http.StatusBadRequest)
 		return
 	}
-
-	// Get the created message info
-	createdMsg, _ := rt.db.GetMessage(msg.ID)
-	sender, _ := rt.db.GetUserByID(ctx.UserID)
-	senderUsername := ""
-	if sender != nil {
-		senderUsername = sender.Username
-	}
-
-	response := messageResponse{
-		ID:             msg.ID,
-		SenderID:       msg.SenderID,
-		SenderUsername: senderUsername,
-		Type:           msg.Type,
-		Timestamp:      createdMsg.CreatedAt,
-		Checkmarks:     1,
-		Forwarded:      false,
-		Comments:       []commentResponse{},
-	}
-
-	if msg.Type == "text" {
-		response.Content = msg.Content
-	} else {
-		response.Content = "/messages/" + msg.ID + "/photo"
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		rt.baseLogger.WithError(err).Error("error encoding response")
-	}
-}
-
-// forwardMessage forwards a message to a conversation
-func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	conversationID := ps.ByName("conversationId")
-
-	// Check membership in target conversation
-	isMember, err := rt.db.IsConversationMember(conversationID, ctx.UserID)
-	if err != nil {
-		rt.baseLogger.WithError(err).Error("error checking membership")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	var req forwardMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if len(msg.AltText) > maxAltTextLength {
+		http.Error(w, "Alt text too long", http.StatusBadRequest)
 		return
 	}
 
-	// Get the original message
-	originalMsg, err := rt.db.GetMessage(req.MessageID)
-	if err != nil {
-		rt.baseLogger.WithError(err).Error("error getting message")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	if originalMsg == nil {
-		http.Error(w, "Original message not found", http.StatusNotFound)
+	if size := messageSize(&msg); size > 0 && !rt.checkQuota(w, ctx.UserID, conversationID, size) {
 		return
 	}
 
-	// Check if user can access the original message (is member of its conversation)
-	isMemberOriginal, err := rt.db.IsConversationMember(originalMsg.ConversationID, ctx.UserID)
-	if err != nil {
-		rt.baseLogger.WithError(err).Error("error checking membership")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	if !isMemberOriginal {
-		http.Error(w, "Original message not found", http.StatusNotFound)
-		return
-	}
-
-	// Create forwarded message
-	newMsg := database.Message{
-		ID:             uuid.New().String(),
-		ConversationID: conversationID,
-		SenderID:       ctx.UserID,
-		Content:        originalMsg.Content,
-		Photo:          originalMsg.Photo,
-		Type:           originalMsg.Type,
-		Forwarded:      true,
-	}
-
-	if err := rt.db.CreateMessage(&newMsg); err != nil {
+	if err := rt.db.CreateMessage(&msg); err != nil {
 		rt.baseLogger.WithError(err).Error("error creating message")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	createdMsg, _ := rt.db.GetMessage(newMsg.ID)
+	// Get the created message info
+	createdMsg, _ := rt.db.GetMessage(msg.ID)
 	sender, _ := rt.db.GetUserByID(ctx.UserID)
 	senderUsername := ""
+	senderBot := false
 	if sender != nil {
 		senderUsername = sender.Username
+		senderBot = sender.Bot
 	}
 
 	response := messageResponse{
-		ID:             newMsg.ID,
-		SenderID:       newMsg.SenderID,
+		ID:             msg.ID,
+		SenderID:       msg.SenderID,
 		SenderUsername: senderUsername,
-		Type:           newMsg.Type,
+		Bot:            senderBot,
+		Type:           msg.Type,
 		Timestamp:      createdMsg.CreatedAt,
 		Checkmarks:     1,
-		Forwarded:      true,
+		Forwarded:      false,
 		Comments:       []commentResponse{},
 	}
 
-	if newMsg.Type == "text" {
-		response.Content = newMsg.Content
-	} else {
-		response.Content = "/messages/" + newMsg.ID + "/photo"
-	}
+	setMessageContent(&response, createdMsg, ctx.UserID)
+	rt.setMessageDetails(&response, createdMsg, ctx.UserID)
+	rt.publish(conversationID, webhook.EventMessageCreated, response)
+	rt.dispatchCommand(createdMsg, sender)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -263,6 +338,89 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	rt.publish(msg.ConversationID, webhook.EventMessageDeleted, messageDeletedEvent{MessageID: messageID, UserID: ctx.UserID})
+	rt.audit(r, ctx.UserID, audit.ActionMessageDeleted, messageID, audit.Details{"conversation": msg.ConversationID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// getMemberMessage loads a message of the given type that the user can see.
+// On failure it writes the error response and returns nil.
+func (rt *_router) getMemberMessage(w http.ResponseWriter, messageID, userID, msgType string) *database.Message {
+	msg, err := rt.db.GetMessage(messageID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if msg == nil || msg.Type != msgType {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil
+	}
+
+	// Check if user is a member of the conversation
+	isMember, err := rt.db.IsConversationMember(msg.ConversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	return msg
+}
+
+// setMessageContent fills the type-specific content fields of a message response as seen by viewerID
+func setMessageContent(resp *messageResponse, msg *database.Message, viewerID string) {
+	// Messages posted by an incoming webhook are shown under its name
+	resp.Integration = msg.Integration
+	resp.Buttons = newButtonResponses(msg.Buttons)
+
+	switch msg.Type {
+	case MessageTypeText:
+		resp.Content = msg.Content
+	case MessageTypeFile:
+		resp.Content = "/messages/" + msg.ID + "/file"
+		resp.File = &fileResponse{
+			Name:     msg.FileName,
+			MimeType: msg.MimeType,
+			Size:     msg.FileSize,
+		}
+	case MessageTypeAudio:
+		resp.Content = "/messages/" + msg.ID + "/audio"
+		resp.DurationMs = msg.DurationMs
+	case MessageTypeAlbum:
+		resp.Album = make([]albumItemResponse, len(msg.Media))
+		for i, item := range msg.Media {
+			url := "/messages/" + msg.ID + "/media/" + strconv.Itoa(item.Position)
+			resp.Album[i] = albumItemResponse{
+				URL:          url,
+				ThumbnailURL: url + "/thumbnail",
+				Caption:      item.Caption,
+				AltText:      item.AltText,
+			}
+		}
+		if len(resp.Album) > 0 {
+			resp.Content = resp.Album[0].URL
+		}
+	case MessageTypePoll:
+		resp.Content = msg.Content
+		if msg.Poll != nil {
+			resp.Poll = newPollResponse(msg.Poll, viewerID)
+		}
+	case MessageTypeContact:
+		// The username is resolved by setContact
+	case MessageTypeLocation:
+		resp.Content = msg.Content
+		if msg.Location != nil {
+			resp.Location = newLocationResponse(msg.Location)
+		}
+	default:
+		resp.Content = "/messages/" + msg.ID + "/photo"
+		resp.Caption = msg.Caption
+		resp.AltText = msg.AltText
+	}
+}
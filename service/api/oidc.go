@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/oidc"
+)
+
+// maxUsernameAttempts bounds the numbered variants tried when a username is taken
+const maxUsernameAttempts = 100
+
+// maxOIDCUserAttempts bounds the tries to create the user of a first login
+const maxOIDCUserAttempts = 3
+
+// oidcBindingCookie ties a login to the browser that started it, so that a callback
+// URL can't be completed elsewhere or forced onto another user's browser
+const oidcBindingCookie = "wasatext_oidc"
+
+// startOIDCLogin sends the user to the identity provider
+func (rt *_router) startOIDCLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if rt.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	authURL, binding, err := rt.oidc.AuthCodeURL(r.Context())
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error starting single sign-on")
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, rt.oidcCookie(binding, int(oidc.LoginTimeout.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCookie returns the binding cookie of a login, sent back only to the callback.
+// Lax lets it follow the top-level redirect from the identity provider.
+func (rt *_router) oidcCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    value,
+		Path:     rt.oidcRedirect.Path,
+		MaxAge:   maxAge,
+		Secure:   rt.oidcRedirect.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// oidcCallback completes a login at the identity provider. The subject is mapped to
+// a user, created on the first login. The session is returned as JSON or, when a
+// post-login URL is configured, in the fragment of a redirect to it.
+func (rt *_router) oidcCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if rt.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, "Login was not completed at the identity provider", http.StatusUnauthorized)
+		return
+	}
+
+	var binding string
+	if cookie, err := r.Cookie(oidcBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+	http.SetCookie(w, rt.oidcCookie("", -1))
+
+	claims, err := rt.oidc.Exchange(r.Context(), query.Get("state"), binding, query.Get("code"))
+	switch {
+	case errors.Is(err, oidc.ErrInvalidState):
+		http.Error(w, "Unknown or expired login, please start again", http.StatusBadRequest)
+		return
+	case errors.Is(err, oidc.ErrInvalidToken):
+		rt.baseLogger.WithError(err).Warn("rejected ID token")
+		http.Error(w, "Invalid identity token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		rt.baseLogger.WithError(err).Error("error completing single sign-on")
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	userID, err := rt.oidcUserID(claims)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting single sign-on user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user.SuspendedAt != "" {
+		rt.audit(r, "", audit.ActionLoginFailed, userID, audit.Details{"reason": "suspended", "method": "oidc"})
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	token, err := rt.newSession(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error creating session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, userID, audit.ActionLogin, userID, audit.Details{"method": "oidc", "issuer": claims.Issuer})
+
+	if rt.oidcPostLoginURL != "" {
+		fragment := url.Values{}
+		fragment.Set("identifier", userID)
+		fragment.Set("token", token)
+		fragment.Set("username", user.Username)
+		http.Redirect(w, r, rt.oidcPostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(loginResponse{Identifier: userID, Token: token}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// oidcUserID returns the user an identity logs in as, creating it on the first login.
+// Concurrent logins can take the chosen username or create the user in between, so
+// creation is retried a few times.
+func (rt *_router) oidcUserID(claims *oidc.Claims) (string, error) {
+	for i := 0; i < maxOIDCUserAttempts; i++ {
+		userID, err := rt.db.GetOIDCUserID(claims.Issuer, claims.Subject)
+		if err != nil || userID != "" {
+			return userID, err
+		}
+
+		username, err := rt.availableUsername(oidcUsername(claims))
+		if err != nil {
+			return "", err
+		}
+		userID = uuid.New().String()
+		err = rt.db.CreateOIDCUser(userID, username, claims.Issuer, claims.Subject)
+		if errors.Is(err, database.ErrUsernameTaken) || errors.Is(err, database.ErrOIDCIdentityExists) {
+			continue
+		}
+		return userID, err
+	}
+	return "", errors.New("user creation kept conflicting with concurrent logins")
+}
+
+// oidcUsername derives a valid username from the claims of an identity
+func oidcUsername(claims *oidc.Claims) string {
+	email, _, _ := strings.Cut(claims.Email, "@")
+	for _, candidate := range []string{claims.PreferredUsername, email, claims.Name} {
+		var b strings.Builder
+		for _, c := range candidate {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+				b.WriteRune(c)
+			case c == ' ', c == '.', c == '-':
+				b.WriteRune('_')
+			}
+		}
+		username := strings.Trim(b.String(), "_")
+		if len(username) > 16 {
+			username = username[:16]
+		}
+		if usernameRegex.MatchString(username) {
+			return username
+		}
+	}
+	return "user"
+}
+
+// availableUsername returns base, or a numbered variant of it when it is taken
+func (rt *_router) availableUsername(base string) (string, error) {
+	for i := 1; i <= maxUsernameAttempts; i++ {
+		username := base
+		if i > 1 {
+			suffix := "_" + strconv.Itoa(i)
+			if len(username)+len(suffix) > 16 {
+				username = username[:16-len(suffix)]
+			}
+			username += suffix
+		}
+
+		user, err := rt.db.GetUserByUsername(username)
+		if err != nil {
+			return "", err
+		}
+		if user == nil {
+			return username, nil
+		}
+	}
+	return "user_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:11], nil
+}
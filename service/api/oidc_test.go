@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/oidc"
+)
+
+// racingOIDCDB loses the first user creation to a concurrent login
+type racingOIDCDB struct {
+	database.AppDatabase
+	conflict error // returned by the first CreateOIDCUser
+
+	users      map[string]string // username -> user ID
+	identities map[string]string // subject -> user ID
+	creations  int
+}
+
+func (db *racingOIDCDB) GetOIDCUserID(_, subject string) (string, error) {
+	return db.identities[subject], nil
+}
+
+func (db *racingOIDCDB) GetUserByUsername(username string) (*database.User, error) {
+	if id, ok := db.users[username]; ok {
+		return &database.User{ID: id, Username: username}, nil
+	}
+	return nil, nil
+}
+
+func (db *racingOIDCDB) CreateOIDCUser(userID, username, _, subject string) error {
+	db.creations++
+	if db.creations == 1 {
+		// The concurrent login commits between our checks and our insert
+		db.users[username] = "winner"
+		if db.conflict == database.ErrOIDCIdentityExists {
+			db.identities[subject] = "winner"
+		}
+		return db.conflict
+	}
+	db.users[username] = userID
+	db.identities[subject] = userID
+	return nil
+}
+
+func TestOIDCUserIDRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict error
+		want     string // user ID, "" for the one created on retry
+	}{
+		{"username taken", database.ErrUsernameTaken, ""},
+		{"user created by the other login", database.ErrOIDCIdentityExists, "winner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &racingOIDCDB{conflict: tt.conflict, users: map[string]string{}, identities: map[string]string{}}
+			rt := &_router{db: db}
+
+			userID, err := rt.oidcUserID(&oidc.Claims{Issuer: "https://idp", Subject: "subject", PreferredUsername: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want != "" && userID != tt.want:
+				t.Errorf("oidcUserID = %s, want %s", userID, tt.want)
+			case tt.want == "" && (userID == "winner" || db.users["alice_2"] != userID):
+				t.Errorf("oidcUserID = %s, want the user created as alice_2", userID)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type setPasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type setPasswordResponse struct {
+	Token string `json:"token"`
+}
+
+// validPassword reports whether a password has an acceptable length
+func validPassword(password string) bool {
+	return len(password) >= minPasswordLength && len(password) <= maxPasswordLength
+}
+
+// hashToken returns the hash a session token is stored and looked up by
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSession starts a session for a user and returns its token
+func (rt *_router) newSession(userID string) (string, error) {
+	b := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := rt.db.CreateSession(hashToken(token), userID); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// checkPassword verifies attempt against a user's stored password, counting failures
+// towards a lockout. On failure it writes an error, using wrongStatus for a wrong
+// password, and returns false. Callers reset the failures once the user is fully
+// authenticated.
+func (rt *_router) checkPassword(w http.ResponseWriter, userID string, stored *database.Password, attempt string, wrongStatus int) bool {
+	if stored.Locked {
+		retryAfter := loginLockout
+		if until, err := time.Parse(time.RFC3339, stored.LockedUntil); err == nil {
+			retryAfter = time.Until(until)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Account temporarily locked", http.StatusLocked)
+		return false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored.Hash), []byte(attempt)) != nil {
+		rt.recordLoginFailure(userID)
+		http.Error(w, "Invalid password", wrongStatus)
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a wrong password or second factor towards a lockout
+func (rt *_router) recordLoginFailure(userID string) {
+	lockUntil := time.Now().Add(loginLockout).UTC().Format(time.RFC3339)
+	if err := rt.db.RecordLoginFailure(userID, maxLoginFailures, lockUntil); err != nil {
+		rt.baseLogger.WithError(err).Error("error recording login failure")
+	}
+}
+
+// resetLoginFailures clears the failures counted against a user who authenticated
+func (rt *_router) resetLoginFailures(userID string, stored *database.Password) {
+	if stored == nil || stored.FailedAttempts == 0 {
+		return
+	}
+	if err := rt.db.ResetLoginFailures(userID); err != nil {
+		rt.baseLogger.WithError(err).Error("error resetting login failures")
+	}
+}
+
+// setPassword sets the user's password, or changes it given the current one. Every
+// other session of the user ends and a new session token is returned.
+func (rt *_router) setPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req setPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !validPassword(req.NewPassword) {
+		http.Error(w, "Password must be 8-72 bytes", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := rt.db.GetPassword(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if stored != nil && !rt.checkPassword(w, userID, stored, req.CurrentPassword, http.StatusForbidden) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error hashing password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.SetPassword(userID, string(hash)); err != nil {
+		rt.baseLogger.WithError(err).Error("error setting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := rt.newSession(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error creating session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.DeleteUserSessions(userID, hashToken(token)); err != nil {
+		rt.baseLogger.WithError(err).Error("error ending sessions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, userID, audit.ActionPasswordSet, userID, audit.Details{"first": strconv.FormatBool(stored == nil)})
+	rt.audit(r, userID, audit.ActionSessionsRevoked, userID, audit.Details{"reason": "password_set"})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(setPasswordResponse{Token: token}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type pollRequest struct {
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multipleChoice"`
+	Anonymous      bool     `json:"anonymous"`
+	ClosesAt       string   `json:"closesAt,omitempty"`
+}
+
+type pollResponse struct {
+	Question       string               `json:"question"`
+	Options        []pollOptionResponse `json:"options"`
+	MultipleChoice bool                 `json:"multipleChoice"`
+	Anonymous      bool                 `json:"anonymous"`
+	ClosesAt       string               `json:"closesAt,omitempty"`
+	Closed         bool                 `json:"closed"`
+	TotalVoters    int                  `json:"totalVoters"`
+	MyVotes        []int                `json:"myVotes"`
+}
+
+type pollOptionResponse struct {
+	Text     string   `json:"text"`
+	Votes    int      `json:"votes"`
+	VoterIDs []string `json:"voterIds,omitempty"`
+}
+
+type voteRequest struct {
+	Options []int `json:"options"`
+}
+
+// newPoll validates a poll request and converts it to a poll to store
+func newPoll(req *pollRequest) (*database.Poll, error) {
+	if req == nil {
+		return nil, errors.New("Poll is required")
+	}
+	if len(req.Question) == 0 || len(req.Question) > maxPollQuestionLength {
+		return nil, errors.New("Poll question must be 1-300 characters")
+	}
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		return nil, errors.New("Polls must have 2-10 options")
+	}
+
+	poll := &database.Poll{
+		Question:       req.Question,
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+	}
+	for i, text := range req.Options {
+		if len(text) == 0 || len(text) > maxPollOptionLength {
+			return nil, errors.New("Poll options must be 1-100 characters")
+		}
+		poll.Options = append(poll.Options, database.PollOption{Position: i, Text: text})
+	}
+
+	if req.ClosesAt != "" {
+		closesAt, err := time.Parse(time.RFC3339, req.ClosesAt)
+		if err != nil {
+			return nil, errors.New("Poll close time must be an RFC 3339 timestamp")
+		}
+		if !closesAt.After(time.Now()) {
+			return nil, errors.New("Poll close time must be in the future")
+		}
+		poll.ClosesAt = closesAt.UTC().Format(time.RFC3339)
+	}
+
+	return poll, nil
+}
+
+// newPollResponse builds the tallies of a poll as seen by viewerID
+func newPollResponse(poll *database.Poll, viewerID string) *pollResponse {
+	resp := &pollResponse{
+		Question:       poll.Question,
+		Options:        make([]pollOptionResponse, len(poll.Options)),
+		MultipleChoice: poll.MultipleChoice,
+		Anonymous:      poll.Anonymous,
+		ClosesAt:       poll.ClosesAt,
+		Closed:         poll.Closed,
+		TotalVoters:    poll.TotalVoters,
+		MyVotes:        []int{},
+	}
+	for i, option := range poll.Options {
+		resp.Options[i] = pollOptionResponse{
+			Text:  option.Text,
+			Votes: len(option.VoterIDs),
+		}
+		if !poll.Anonymous {
+			resp.Options[i].VoterIDs = option.VoterIDs
+		}
+		for _, voterID := range option.VoterIDs {
+			if voterID == viewerID {
+				resp.MyVotes = append(resp.MyVotes, option.Position)
+			}
+		}
+	}
+	return resp
+}
+
+// votePoll sets the options the user votes for, replacing any previous vote
+func (rt *_router) votePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypePoll)
+	if msg == nil {
+		return
+	}
+	if msg.Poll == nil {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	if msg.Poll.Closed {
+		http.Error(w, "Poll is closed", http.StatusConflict)
+		return
+	}
+
+	var req voteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Options) == 0 || (!msg.Poll.MultipleChoice && len(req.Options) > 1) {
+		http.Error(w, "Invalid number of options", http.StatusBadRequest)
+		return
+	}
+	chosen := make(map[int]bool, len(req.Options))
+	for _, position := range req.Options {
+		if position < 0 || position >= len(msg.Poll.Options) || chosen[position] {
+			http.Error(w, "Invalid option", http.StatusBadRequest)
+			return
+		}
+		chosen[position] = true
+	}
+
+	if err := rt.db.SetPollVote(msg.ID, ctx.UserID, req.Options); err != nil {
+		rt.baseLogger.WithError(err).Error("error voting")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishMessage(msg.ConversationID, webhook.EventMessageUpdated, msg.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unvotePoll withdraws the user's vote
+func (rt *_router) unvotePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypePoll)
+	if msg == nil {
+		return
+	}
+	if msg.Poll == nil {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	if msg.Poll.Closed {
+		http.Error(w, "Poll is closed", http.StatusConflict)
+		return
+	}
+
+	err := rt.db.RemovePollVote(msg.ID, ctx.UserID)
+	if errors.Is(err, database.ErrVoteNotFound) {
+		http.Error(w, "Vote not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		rt.baseLogger.WithError(err).Error("error removing poll vote")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishMessage(msg.ConversationID, webhook.EventMessageUpdated, msg.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// closePoll lets the author stop a poll before its close time
+func (rt *_router) closePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	msg := rt.getMemberMessage(w, ps.ByName("messageId"), ctx.UserID, MessageTypePoll)
+	if msg == nil {
+		return
+	}
+
+	// Only the author can close
+	if msg.SenderID != ctx.UserID {
+		http.Error(w, "Forbidden - only the author can close the poll", http.StatusForbidden)
+		return
+	}
+
+	if err := rt.db.ClosePoll(msg.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error closing poll")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.publishMessage(msg.ConversationID, webhook.EventMessageUpdated, msg.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+type privacyResponse struct {
+	ForwardAttribution string `json:"forwardAttribution"`
+	PhotoVisibility    string `json:"photoVisibility"`
+	GroupAdd           string `json:"groupAdd"`
+	ReadReceipts       bool   `json:"readReceipts"`
+	Searchable         bool   `json:"searchable"`
+}
+
+// privacyRequest holds the settings to change, omitted ones are left as they are
+type privacyRequest struct {
+	ForwardAttribution *string `json:"forwardAttribution"`
+	PhotoVisibility    *string `json:"photoVisibility"`
+	GroupAdd           *string `json:"groupAdd"`
+	ReadReceipts       *bool   `json:"readReceipts"`
+	Searchable         *bool   `json:"searchable"`
+}
+
+// getMyPrivacy returns the user's privacy settings
+func (rt *_router) getMyPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	settings, err := rt.db.GetPrivacySettings(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rt.writePrivacy(w, settings)
+}
+
+// setMyPrivacy changes the user's privacy settings
+func (rt *_router) setMyPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req privacyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := rt.db.GetPrivacySettings(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if req.ForwardAttribution != nil {
+		switch *req.ForwardAttribution {
+		case database.AudienceEveryone, database.AudienceNobody:
+			settings.ForwardAttribution = *req.ForwardAttribution
+		default:
+			http.Error(w, "Invalid forward attribution", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.PhotoVisibility != nil {
+		if !isAudience(*req.PhotoVisibility) {
+			http.Error(w, "Invalid photo visibility", http.StatusBadRequest)
+			return
+		}
+		settings.PhotoVisibility = *req.PhotoVisibility
+	}
+	if req.GroupAdd != nil {
+		if !isAudience(*req.GroupAdd) {
+			http.Error(w, "Invalid group add setting", http.StatusBadRequest)
+			return
+		}
+		settings.GroupAdd = *req.GroupAdd
+	}
+	if req.ReadReceipts != nil {
+		settings.ReadReceipts = *req.ReadReceipts
+	}
+	if req.Searchable != nil {
+		settings.Searchable = *req.Searchable
+	}
+
+	if err := rt.db.UpdatePrivacySettings(userID, settings); err != nil {
+		rt.baseLogger.WithError(err).Error("error updating privacy settings")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rt.writePrivacy(w, settings)
+}
+
+// writePrivacy encodes privacy settings as the response
+func (rt *_router) writePrivacy(w http.ResponseWriter, settings *database.PrivacySettings) {
+	response := privacyResponse{
+		ForwardAttribution: settings.ForwardAttribution,
+		PhotoVisibility:    settings.PhotoVisibility,
+		GroupAdd:           settings.GroupAdd,
+		ReadReceipts:       settings.ReadReceipts,
+		Searchable:         settings.Searchable,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// isAudience reports whether value is a valid audience for a privacy setting
+func isAudience(value string) bool {
+	switch value {
+	case database.AudienceEveryone, database.AudienceContacts, database.AudienceNobody:
+		return true
+	}
+	return false
+}
+
+// inAudience reports whether viewerID belongs to the audience ownerID chose for a setting.
+// Users always belong to the audience of their own settings.
+func (rt *_router) inAudience(audience, ownerID, viewerID string) (bool, error) {
+	if ownerID == viewerID {
+		return true, nil
+	}
+	switch audience {
+	case database.AudienceEveryone:
+		return true, nil
+	case database.AudienceContacts:
+		return rt.db.SharesConversation(ownerID, viewerID)
+	}
+	return false, nil
+}
+
+// userPhotoURL returns the URL of a user's profile photo, or nil if they have none
+// or viewerID is not allowed to see it
+func (rt *_router) userPhotoURL(user *database.User, viewerID string) *string {
+	if len(user.Photo) == 0 {
+		return nil
+	}
+
+	settings, err := rt.db.GetPrivacySettings(user.ID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting privacy settings")
+		return nil
+	}
+	visible, err := rt.inAudience(settings.PhotoVisibility, user.ID, viewerID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking photo visibility")
+		return nil
+	}
+	if !visible {
+		return nil
+	}
+
+	photoURL := "/users/" + user.ID + "/photo"
+	return &photoURL
+}
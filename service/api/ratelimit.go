@@ -0,0 +1,78 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+)
+
+// authenticatedHandler is the signature of handlers wrapped by wrap
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext)
+
+// limitByIP applies the named rate limit to an unauthenticated route, per client IP
+func (rt *_router) limitByIP(name string, fn httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !rt.allow(w, name, rt.clientIP(r)) {
+			return
+		}
+		fn(w, r, ps)
+	}
+}
+
+// limitByUser applies the named rate limit to an authenticated route, per user
+func (rt *_router) limitByUser(name string, fn authenticatedHandler) authenticatedHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if !rt.allow(w, name, ctx.UserID) {
+			return
+		}
+		fn(w, r, ps, ctx)
+	}
+}
+
+// allow takes a token for key from the named limiter. When the limit is exceeded
+// it writes a 429 response with Retry-After and returns false.
+func (rt *_router) allow(w http.ResponseWriter, name, key string) bool {
+	limiter, ok := rt.limiters[name]
+	if !ok {
+		return true
+	}
+
+	allowed, wait := limiter.Allow(key)
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// clientIP returns the address of the client. Behind trusted reverse proxies it is
+// the address the outermost one received the request from: the entries before it in
+// X-Forwarded-For come from the client and can be forged.
+func (rt *_router) clientIP(r *http.Request) string {
+	if rt.trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+		if len(hops) > 0 {
+			// With fewer entries than proxies, every entry was added by one of them
+			hop := strings.TrimSpace(hops[max(0, len(hops)-rt.trustedProxies)])
+			if ip, err := netip.ParseAddr(hop); err == nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies int
+		forwardedFor   []string
+		want           string
+	}{
+		{"no proxy", 0, nil, "192.0.2.1"},
+		{"header ignored without proxies", 0, []string{"203.0.113.7"}, "192.0.2.1"},
+		{"one proxy", 1, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"one proxy, spoofed entries", 1, []string{"10.0.0.1, 198.51.100.9, 203.0.113.7"}, "203.0.113.7"},
+		{"two proxies", 2, []string{"10.0.0.1, 203.0.113.7, 198.51.100.9"}, "203.0.113.7"},
+		{"several headers", 1, []string{"10.0.0.1", "203.0.113.7"}, "203.0.113.7"},
+		{"several headers, two proxies", 2, []string{"10.0.0.1, 203.0.113.7", "198.51.100.9"}, "203.0.113.7"},
+		{"fewer entries than proxies", 3, []string{"203.0.113.7, 198.51.100.9"}, "203.0.113.7"},
+		{"ipv6", 1, []string{"2001:db8::1"}, "2001:db8::1"},
+		{"spaces", 1, []string{"  203.0.113.7  "}, "203.0.113.7"},
+		{"not an address", 1, []string{"unknown"}, "192.0.2.1"},
+		{"empty entry", 1, []string{"203.0.113.7, "}, "192.0.2.1"},
+		{"no header behind a proxy", 1, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &_router{trustedProxies: tt.trustedProxies}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:41234"
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := rt.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
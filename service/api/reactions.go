@@ -6,6 +6,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/webhook"
 )
 
 type commentRequest struct {
@@ -39,6 +40,9 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
+	if !rt.checkCanSend(w, msg.ConversationID, ctx.UserID) {
+		return
+	}
 
 	var req commentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -56,6 +60,7 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	rt.publishReaction(msg.ConversationID, webhook.EventReactionAdded, messageID, ctx.UserID, req.Comment)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -93,6 +98,7 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
+	rt.publishReaction(msg.ConversationID, webhook.EventReactionRemoved, messageID, ctx.UserID, "")
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,513 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type reportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type reportDecisionRequest struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+type reportResponse struct {
+	ID             string                   `json:"id"`
+	ReporterID     string                   `json:"reporterId"`
+	MessageID      string                   `json:"messageId,omitempty"`
+	ConversationID string                   `json:"conversationId,omitempty"`
+	ReportedUserID string                   `json:"reportedUserId"`
+	Reason         string                   `json:"reason"`
+	Details        string                   `json:"details,omitempty"`
+	Status         string                   `json:"status"`
+	CreatedAt      string                   `json:"createdAt"`
+	Snapshot       json.RawMessage          `json:"snapshot"`
+	Media          []string                 `json:"media,omitempty"`
+	Decisions      []reportDecisionResponse `json:"decisions"`
+}
+
+type reportDecisionResponse struct {
+	AdminID   string `json:"adminId"`
+	Action    string `json:"action"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type reportsResponse struct {
+	Reports    []reportResponse `json:"reports"`
+	NextOffset int              `json:"nextOffset,omitempty"`
+}
+
+// reportSnapshot is what the reporter saw, stored with the report. Photos and files
+// are stored next to it as report media.
+type reportSnapshot struct {
+	Username string           `json:"username"`
+	Bot      bool             `json:"bot"`
+	Message  *messageSnapshot `json:"message,omitempty"`
+}
+
+type messageSnapshot struct {
+	Type          string            `json:"type"`
+	Content       string            `json:"content,omitempty"` // text, poll question or location label
+	Caption       string            `json:"caption,omitempty"`
+	AltText       string            `json:"altText,omitempty"`
+	File          *fileResponse     `json:"file,omitempty"`
+	DurationMs    int64             `json:"durationMs,omitempty"`
+	Album         []albumSnapshot   `json:"album,omitempty"`
+	Poll          *pollResponse     `json:"poll,omitempty"`
+	Location      *locationResponse `json:"location,omitempty"`
+	ContactUserID string            `json:"contactUserId,omitempty"`
+	Integration   string            `json:"integration,omitempty"`
+	Forwarded     bool              `json:"forwarded"`
+	SentAt        string            `json:"sentAt"`
+}
+
+type albumSnapshot struct {
+	Caption string `json:"caption,omitempty"`
+	AltText string `json:"altText,omitempty"`
+}
+
+// reportMessage reports a message the user can see to the server admins
+func (rt *_router) reportMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	req, ok := decodeReportRequest(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := rt.db.GetMessage(ps.ByName("messageId"))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	isMember, err := rt.db.IsConversationMember(msg.ConversationID, ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking membership")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if msg.SenderID == ctx.UserID {
+		http.Error(w, "You can't report your own messages", http.StatusBadRequest)
+		return
+	}
+
+	sender, err := rt.db.GetUserByID(msg.SenderID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting sender")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Messages of deleted accounts stay in the conversation and can still be reported
+	senderUsername := deletedUsername
+	senderBot := false
+	if sender != nil {
+		senderUsername = sender.Username
+		senderBot = sender.Bot
+	}
+
+	snapshot, media := newMessageSnapshot(msg)
+	report := &database.Report{
+		ReporterID:     ctx.UserID,
+		MessageID:      msg.ID,
+		ConversationID: msg.ConversationID,
+		ReportedUserID: msg.SenderID,
+		Reason:         req.Reason,
+		Details:        req.Details,
+	}
+	rt.createReport(w, report, reportSnapshot{Username: senderUsername, Bot: senderBot, Message: snapshot}, media)
+}
+
+// reportUser reports a user to the server admins
+func (rt *_router) reportUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	req, ok := decodeReportRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := rt.db.GetUserByID(ps.ByName("userId"))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.ID == ctx.UserID {
+		http.Error(w, "You can't report yourself", http.StatusBadRequest)
+		return
+	}
+
+	var media []database.ReportMedia
+	if len(user.Photo) > 0 {
+		media = append(media, database.ReportMedia{MimeType: http.DetectContentType(user.Photo), Data: user.Photo})
+	}
+	report := &database.Report{
+		ReporterID:     ctx.UserID,
+		ReportedUserID: user.ID,
+		Reason:         req.Reason,
+		Details:        req.Details,
+	}
+	rt.createReport(w, report, reportSnapshot{Username: user.Username, Bot: user.Bot}, media)
+}
+
+// createReport stores a report unless the reporter already has an open one for the same content
+func (rt *_router) createReport(w http.ResponseWriter, report *database.Report, snapshot reportSnapshot, media []database.ReportMedia) {
+	exists, err := rt.db.HasOpenReport(report.ReporterID, report.MessageID, report.ReportedUserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking reports")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "You already reported this", http.StatusConflict)
+		return
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding report snapshot")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	report.ID = uuid.NewString()
+	report.Snapshot = string(data)
+
+	if err := rt.db.CreateReport(report, media); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating report")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := rt.db.GetReport(report.ID)
+	if err != nil || stored == nil {
+		rt.baseLogger.WithError(err).Error("error getting report")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newReportResponse(stored)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getReports lists the moderation queue: the open reports, oldest first, or the
+// reports with the status given in the query
+func (rt *_router) getReports(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.ReportOpen
+	case database.ReportOpen, database.ReportDismissed, database.ReportActioned:
+	default:
+		http.Error(w, "Status must be open, dismissed or actioned", http.StatusBadRequest)
+		return
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	// Fetch one extra report to know whether there is a next page
+	reports, err := rt.db.GetReports(status, reportsPageSize+1, offset)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting reports")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := reportsResponse{Reports: []reportResponse{}}
+	if len(reports) > reportsPageSize {
+		reports = reports[:reportsPageSize]
+		response.NextOffset = offset + reportsPageSize
+	}
+	for i := range reports {
+		response.Reports = append(response.Reports, newReportResponse(&reports[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getReport returns a report with the decisions taken on it
+func (rt *_router) getReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	report := rt.getReportByID(w, ps.ByName("reportId"))
+	if report == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newReportResponse(report)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getReportMedia returns a photo or file of the snapshot of a report. Photos are shown
+// inline, anything else is downloaded.
+func (rt *_router) getReportMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	position, err := strconv.Atoi(ps.ByName("position"))
+	if err != nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	media, err := rt.db.GetReportMedia(ps.ByName("reportId"), position)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting report media")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if media == nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	if strings.HasPrefix(http.DetectContentType(media.Data), "image/") {
+		rt.writeImage(w, media.Data)
+		return
+	}
+	w.Header().Set("Content-Type", media.MimeType)
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(media.Data); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing report media")
+	}
+}
+
+// decideReport takes an action on a report: dismiss it, delete the reported message or
+// suspend the reported user. Every decision is kept with the admin who took it.
+func (rt *_router) decideReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var req reportDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > maxReportNoteLength {
+		http.Error(w, "Note must be at most 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	report := rt.getReportByID(w, ps.ByName("reportId"))
+	if report == nil {
+		return
+	}
+
+	status := database.ReportActioned
+	switch req.Action {
+	case reportActionDismiss:
+		status = database.ReportDismissed
+	case reportActionDeleteMessage:
+		if report.MessageID == "" {
+			http.Error(w, "The report is not about a message", http.StatusBadRequest)
+			return
+		}
+		if !rt.deleteReportedMessage(w, report.MessageID, ctx.UserID) {
+			return
+		}
+	case reportActionSuspendUser:
+		user := rt.getAdminTarget(w, report.ReportedUserID)
+		if user == nil {
+			return
+		}
+		if user.Admin {
+			http.Error(w, "Admins can't be suspended, revoke their admin role first", http.StatusConflict)
+			return
+		}
+		if err := rt.db.SuspendUser(user.ID); err != nil {
+			rt.baseLogger.WithError(err).Error("error suspending user")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Action must be dismiss, delete_message or suspend_user", http.StatusBadRequest)
+		return
+	}
+
+	decision := database.ReportDecision{AdminID: ctx.UserID, Action: req.Action, Note: req.Note}
+	if err := rt.db.AddReportDecision(report.ID, decision, status); err != nil {
+		rt.baseLogger.WithError(err).Error("error recording report decision")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionAdminReportDecision, report.ID, audit.Details{
+		"action":  req.Action,
+		"message": report.MessageID,
+		"user":    report.ReportedUserID,
+	})
+
+	report = rt.getReportByID(w, report.ID)
+	if report == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newReportResponse(report)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// deleteReportedMessage deletes a reported message, unless its sender already did
+func (rt *_router) deleteReportedMessage(w http.ResponseWriter, messageID, adminID string) bool {
+	msg, err := rt.db.GetMessage(messageID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if msg == nil {
+		return true
+	}
+
+	if err := rt.db.DeleteMessage(messageID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting message")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	rt.publish(msg.ConversationID, webhook.EventMessageDeleted, messageDeletedEvent{MessageID: messageID, UserID: adminID})
+	return true
+}
+
+// getReportByID loads a report, or writes an error and returns nil
+func (rt *_router) getReportByID(w http.ResponseWriter, reportID string) *database.Report {
+	report, err := rt.db.GetReport(reportID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting report")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if report == nil {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return nil
+	}
+	return report
+}
+
+// decodeReportRequest reads and validates the reason of a report, or writes an error
+func decodeReportRequest(w http.ResponseWriter, r *http.Request) (reportRequest, bool) {
+	var req reportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if !isReportReason(req.Reason) {
+		http.Error(w, "Reason must be one of "+strings.Join(reportReasons, ", "), http.StatusBadRequest)
+		return req, false
+	}
+	if utf8.RuneCountInString(req.Details) > maxReportDetailsLength {
+		http.Error(w, "Details must be at most 1000 characters", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func isReportReason(reason string) bool {
+	for _, r := range reportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// newMessageSnapshot copies a message, and its photos and files, into a report
+func newMessageSnapshot(msg *database.Message) (*messageSnapshot, []database.ReportMedia) {
+	snapshot := &messageSnapshot{
+		Type:          msg.Type,
+		ContactUserID: msg.ContactUserID,
+		Integration:   msg.Integration,
+		Forwarded:     msg.Forwarded,
+		SentAt:        msg.CreatedAt,
+	}
+	var media []database.ReportMedia
+
+	switch msg.Type {
+	case MessageTypeText:
+		snapshot.Content = msg.Content
+	case MessageTypeContact:
+	case MessageTypeFile, MessageTypeAudio:
+		snapshot.File = &fileResponse{Name: msg.FileName, MimeType: msg.MimeType, Size: msg.FileSize}
+		snapshot.DurationMs = msg.DurationMs
+		media = append(media, database.ReportMedia{MimeType: msg.MimeType, Data: msg.FileData})
+	case MessageTypeAlbum:
+		for _, item := range msg.Media {
+			snapshot.Album = append(snapshot.Album, albumSnapshot{Caption: item.Caption, AltText: item.AltText})
+			media = append(media, database.ReportMedia{MimeType: http.DetectContentType(item.Photo), Data: item.Photo})
+		}
+	case MessageTypePoll:
+		snapshot.Content = msg.Content
+		if msg.Poll != nil {
+			snapshot.Poll = newPollResponse(msg.Poll, "")
+		}
+	case MessageTypeLocation:
+		snapshot.Content = msg.Content
+		if msg.Location != nil {
+			snapshot.Location = newLocationResponse(msg.Location)
+		}
+	default:
+		snapshot.Caption = msg.Caption
+		snapshot.AltText = msg.AltText
+		media = append(media, database.ReportMedia{MimeType: http.DetectContentType(msg.Photo), Data: msg.Photo})
+	}
+	return snapshot, media
+}
+
+// newReportResponse converts a report to its API representation
+func newReportResponse(report *database.Report) reportResponse {
+	response := reportResponse{
+		ID:             report.ID,
+		ReporterID:     report.ReporterID,
+		MessageID:      report.MessageID,
+		ConversationID: report.ConversationID,
+		ReportedUserID: report.ReportedUserID,
+		Reason:         report.Reason,
+		Details:        report.Details,
+		Status:         report.Status,
+		CreatedAt:      report.CreatedAt,
+		Snapshot:       json.RawMessage(report.Snapshot),
+		Decisions:      make([]reportDecisionResponse, len(report.Decisions)),
+	}
+	for i := 0; i < report.MediaCount; i++ {
+		response.Media = append(response.Media, "/admin/reports/"+report.ID+"/media/"+strconv.Itoa(i))
+	}
+	for i, d := range report.Decisions {
+		response.Decisions[i] = reportDecisionResponse{
+			AdminID:   d.AdminID,
+			Action:    d.Action,
+			Note:      d.Note,
+			CreatedAt: d.CreatedAt,
+		}
+	}
+	return response
+}
@@ -1,6 +1,17 @@
 package reqcontext
 
+import "github.com/sapienzaapps/wasatext/service/database"
+
 // RequestContext contains the context for a request
 type RequestContext struct {
 	UserID string
+
+	// TokenHash identifies the session of the request, it is empty when the token is a user ID
+	TokenHash string
+
+	// APIKey is the key a bot authenticated with, nil for users
+	APIKey *database.APIKey
+
+	// Admin is whether the user is a server admin
+	Admin bool
 }
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+)
+
+// getMessageRequests lists the private conversations started by non-contacts that the user has not accepted
+func (rt *_router) getMessageRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	previews, err := rt.db.GetMessageRequests(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message requests")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newConversationPreviewResponses(previews)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// acceptMessageRequest moves a message request to the user's conversations
+func (rt *_router) acceptMessageRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.checkMessageRequest(w, ps, ctx) {
+		return
+	}
+
+	if err := rt.db.AcceptMessageRequest(ps.ByName("conversationId"), ctx.UserID); err != nil {
+		http.Error(w, "Message request not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// declineMessageRequest deletes a message request with its messages. The sender can't
+// send the user a new one for requestDeclineCooldown.
+func (rt *_router) declineMessageRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.checkMessageRequest(w, ps, ctx) {
+		return
+	}
+
+	if err := rt.db.DeclineMessageRequest(ps.ByName("conversationId"), ctx.UserID); err != nil {
+		rt.baseLogger.WithError(err).Error("error declining message request")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// blockMessageRequest blocks the sender of a message request and deletes it
+func (rt *_router) blockMessageRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if !rt.checkMessageRequest(w, ps, ctx) {
+		return
+	}
+	conversationID := ps.ByName("conversationId")
+
+	members, err := rt.db.GetGroupMembers(conversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting members")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, m := range members {
+		if m.ID == ctx.UserID {
+			continue
+		}
+		if err := rt.db.BlockUser(ctx.UserID, m.ID); err != nil {
+			rt.baseLogger.WithError(err).Error("error blocking user")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := rt.db.DeleteConversation(conversationID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting conversation")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkMessageRequest verifies that the conversation in the path is a message request
+// sent to the user in the path, who must be the authenticated user: only the recipient
+// can accept, decline or block a request. On failure it writes the error response and
+// returns false.
+func (rt *_router) checkMessageRequest(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext) bool {
+	if ps.ByName("userId") != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	pending, err := rt.db.IsMessageRequest(ps.ByName("conversationId"), ctx.UserID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking message request")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !pending {
+		http.Error(w, "Message request not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// checkCanSend verifies that userID, a member of the conversation, may send into it:
+// they were not blocked and, if it is a message request to them, they accepted it.
+// On failure it writes the error response and returns false.
+func (rt *_router) checkCanSend(w http.ResponseWriter, conversationID, userID string) bool {
+	if !rt.checkNotBlocked(w, conversationID, userID) {
+		return false
+	}
+
+	pending, err := rt.db.IsMessageRequest(conversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking message request")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if pending {
+		http.Error(w, "Accept the message request first", http.StatusConflict)
+		return false
+	}
+	return true
+}
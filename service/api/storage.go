@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+type storageResponse struct {
+	TotalBytes        int64                         `json:"totalBytes"`
+	QuotaBytes        int64                         `json:"quotaBytes,omitempty"`
+	ProfilePhotoBytes int64                         `json:"profilePhotoBytes"`
+	ByType            map[string]int64              `json:"byType"`
+	Conversations     []conversationStorageResponse `json:"conversations"`
+}
+
+type conversationStorageResponse struct {
+	ConversationID string `json:"conversationId"`
+	Bytes          int64  `json:"bytes"`
+}
+
+// messageSize returns the number of bytes of media a message stores
+func messageSize(msg *database.Message) int64 {
+	size := int64(len(msg.Photo) + len(msg.FileData))
+	for _, item := range msg.Media {
+		size += int64(len(item.Photo))
+	}
+	return size
+}
+
+// checkQuota verifies that storing added more bytes keeps the user and the conversation
+// within their quotas; an empty ID skips that check. On failure it writes the error
+// response and returns false.
+func (rt *_router) checkQuota(w http.ResponseWriter, userID, conversationID string, added int64) bool {
+	if userID != "" && rt.userQuota > 0 {
+		usage, err := rt.db.GetUserStorage(userID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting user storage")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return false
+		}
+		if usage.Total+added > rt.userQuota {
+			http.Error(w, "User storage quota exceeded", http.StatusInsufficientStorage)
+			return false
+		}
+	}
+
+	if conversationID != "" && rt.conversationQuota > 0 {
+		used, err := rt.db.GetConversationStorage(conversationID)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("error getting conversation storage")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return false
+		}
+		if used+added > rt.conversationQuota {
+			http.Error(w, "Conversation storage quota exceeded", http.StatusInsufficientStorage)
+			return false
+		}
+	}
+
+	return true
+}
+
+// getMyStorage returns how much media the user has uploaded
+func (rt *_router) getMyStorage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	usage, err := rt.db.GetUserStorage(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting user storage")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := storageResponse{
+		TotalBytes:        usage.Total,
+		QuotaBytes:        rt.userQuota,
+		ProfilePhotoBytes: usage.ProfilePhoto,
+		ByType:            usage.ByType,
+		Conversations:     make([]conversationStorageResponse, len(usage.ByConversation)),
+	}
+	for i, c := range usage.ByConversation {
+		response.Conversations[i] = conversationStorageResponse{
+			ConversationID: c.ConversationID,
+			Bytes:          c.Bytes,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
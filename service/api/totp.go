@@ -0,0 +1,338 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/totp"
+	"github.com/skip2/go-qrcode"
+)
+
+// recoveryCodeAlphabet leaves out characters that are easily confused
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+type totpStatusResponse struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recoveryCodes"`
+}
+
+type totpEnrolmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted as xxxxx-xxxxx, and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength)
+		for j := range b {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			b[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+		codes[i] = string(b[:recoveryCodeLength/2]) + "-" + string(b[recoveryCodeLength/2:])
+		hashes[i] = hashToken(string(b))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes regardless of case, dashes and spaces
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor verifies a code from the user's authenticator, or one of their
+// recovery codes, counting failures towards a lockout. On failure it writes an error,
+// using wrongStatus for a missing or wrong code, and returns false.
+func (rt *_router) checkSecondFactor(w http.ResponseWriter, userID string, secondFactor *database.TOTP, code string, wrongStatus int) bool {
+	if code == "" {
+		http.Error(w, "Two-factor code required", wrongStatus)
+		return false
+	}
+
+	var ok bool
+	var err error
+	if step, valid := totp.Verify(secondFactor.Secret, code, time.Now()); valid {
+		ok, err = rt.db.UseTOTPStep(userID, step)
+	} else {
+		ok, err = rt.db.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking two-factor code")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		rt.recordLoginFailure(userID)
+		http.Error(w, "Invalid two-factor code", wrongStatus)
+		return false
+	}
+	return true
+}
+
+// getTOTP returns whether the user has two-factor authentication enabled
+func (rt *_router) getTOTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	secondFactor, err := rt.db.GetTOTP(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var response totpStatusResponse
+	if secondFactor != nil && secondFactor.Enabled {
+		response.Enabled = true
+		response.RecoveryCodes = secondFactor.RecoveryCodes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// enrollTOTP starts enabling two-factor authentication with a new secret. It is
+// only turned on once a code from the authenticator confirms the enrolment.
+func (rt *_router) enrollTOTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// A second factor needs a first one
+	password, err := rt.db.GetPassword(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if password == nil {
+		http.Error(w, "Set a password first", http.StatusConflict)
+		return
+	}
+
+	secondFactor, err := rt.db.GetTOTP(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if secondFactor != nil && secondFactor.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.SetTOTPSecret(userID, secret); err != nil {
+		rt.baseLogger.WithError(err).Error("error setting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	response := totpEnrolmentResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Username, secret),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// getTOTPQRCode returns the otpauth:// URI of a pending enrolment as a QR code PNG
+func (rt *_router) getTOTPQRCode(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	secondFactor, err := rt.db.GetTOTP(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if secondFactor == nil || secondFactor.Enabled {
+		http.Error(w, "No pending enrolment", http.StatusNotFound)
+		return
+	}
+
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Username, secondFactor.Secret), qrcode.Medium, totpQRCodeSize)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding QR code")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(png); err != nil {
+		rt.baseLogger.WithError(err).Error("error writing QR code")
+	}
+}
+
+// confirmTOTP turns on two-factor authentication once the authenticator shows a valid
+// code, and returns the recovery codes. They are stored hashed and shown only now.
+func (rt *_router) confirmTOTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secondFactor, err := rt.db.GetTOTP(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if secondFactor == nil || secondFactor.Enabled {
+		http.Error(w, "No pending enrolment", http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Verify(secondFactor.Secret, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if _, err := rt.db.UseTOTPStep(userID, step); err != nil {
+		rt.baseLogger.WithError(err).Error("error using two-factor code")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating recovery codes")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.EnableTOTP(userID, hashes); err != nil {
+		rt.baseLogger.WithError(err).Error("error enabling two-factor authentication")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, userID, audit.ActionTOTPEnabled, userID, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(recoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// disableTOTP turns off two-factor authentication. The user authenticates again with
+// their password and a code, so that a stolen session cannot remove the second factor.
+func (rt *_router) disableTOTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ps.ByName("userId")
+
+	if userID != ctx.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req disableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secondFactor, err := rt.db.GetTOTP(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting two-factor secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if secondFactor == nil || !secondFactor.Enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	password, err := rt.db.GetPassword(userID)
+	if err != nil || password == nil {
+		rt.baseLogger.WithError(err).Error("error getting password")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !rt.checkPassword(w, userID, password, req.Password, http.StatusForbidden) {
+		return
+	}
+	if !rt.checkSecondFactor(w, userID, secondFactor, req.Code, http.StatusForbidden) {
+		return
+	}
+	rt.resetLoginFailures(userID, password)
+
+	if err := rt.db.DeleteTOTP(userID); err != nil {
+		rt.baseLogger.WithError(err).Error("error disabling two-factor authentication")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, userID, audit.ActionTOTPDisabled, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
 )
 
 type setUsernameRequest struct {
@@ -17,6 +18,7 @@ type userResponse struct {
 	ID       string  `json:"id"`
 	Username string  `json:"username"`
 	PhotoURL *string `json:"photoUrl,omitempty"`
+	Bot      bool    `json:"bot"`
 }
 
 // setMyUserName handles username change
@@ -35,31 +37,48 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
-	if !usernameRegex.MatchString(req.Username) {
-		http.Error(w, "Invalid username format", http.StatusBadRequest)
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !rt.changeUsername(w, userID, req.Username) {
 		return
 	}
+	rt.audit(r, userID, audit.ActionUsernameChanged, userID, audit.Details{"from": user.Username, "to": req.Username})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changeUsername validates and sets the username of a user, or writes an error and
+// returns false
+func (rt *_router) changeUsername(w http.ResponseWriter, userID, username string) bool {
+	if !usernameRegex.MatchString(username) {
+		http.Error(w, "Invalid username format", http.StatusBadRequest)
+		return false
+	}
 
 	// Check if username is taken
-	existingUser, err := rt.db.GetUserByUsername(req.Username)
+	existingUser, err := rt.db.GetUserByUsername(username)
 	if err != nil {
 		rt.baseLogger.WithError(err).Error("error checking username")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
 	if existingUser != nil && existingUser.ID != userID {
 		http.Error(w, "Username already taken", http.StatusConflict)
-		return
+		return false
 	}
 
-	err = rt.db.UpdateUsername(userID, req.Username)
+	err = rt.db.UpdateUsername(userID, username)
 	if err != nil {
 		rt.baseLogger.WithError(err).Error("error updating username")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	return true
 }
 
 // setMyPhoto handles profile photo upload
@@ -86,6 +105,17 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
+	// The new photo replaces the old one in the user's quota
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user != nil && !rt.checkQuota(w, userID, "", int64(len(photo)-len(user.Photo))) {
+		return
+	}
+
 	err = rt.db.UpdateUserPhoto(userID, photo)
 	if err != nil {
 		rt.baseLogger.WithError(err).Error("error updating photo")
@@ -104,7 +134,7 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	users, err := rt.db.SearchUsers(query)
+	users, err := rt.db.SearchUsers(query, ctx.UserID)
 	if err != nil {
 		rt.baseLogger.WithError(err).Error("error searching users")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -116,10 +146,8 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httpro
 		response[i] = userResponse{
 			ID:       u.ID,
 			Username: u.Username,
-		}
-		if len(u.Photo) > 0 {
-			photoURL := "/users/" + u.ID + "/photo"
-			response[i].PhotoURL = &photoURL
+			PhotoURL: rt.userPhotoURL(&u, ctx.UserID),
+			Bot:      u.Bot,
 		}
 	}
 
@@ -0,0 +1,427 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sapienzaapps/wasatext/service/api/reqcontext"
+	"github.com/sapienzaapps/wasatext/service/audit"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type webhookResponse struct {
+	ID             string   `json:"id"`
+	ConversationID string   `json:"conversationId"`
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	Secret         string   `json:"secret,omitempty"`
+	CreatedBy      string   `json:"createdBy"`
+	CreatedAt      string   `json:"createdAt"`
+}
+
+type webhookDeliveryResponse struct {
+	ID             string          `json:"id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  string          `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      string          `json:"createdAt"`
+	DeliveredAt    string          `json:"deliveredAt,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// Data of the webhook events that do not carry a whole message
+type (
+	messageDeletedEvent struct {
+		MessageID string `json:"messageId"`
+		UserID    string `json:"userId"`
+	}
+
+	reactionEvent struct {
+		MessageID string `json:"messageId"`
+		UserID    string `json:"userId"`
+		Username  string `json:"username"`
+		Comment   string `json:"comment,omitempty"`
+	}
+
+	memberEvent struct {
+		UserID   string `json:"userId"`
+		Username string `json:"username"`
+		Bot      bool   `json:"bot"`
+		ByUserID string `json:"byUserId"`
+	}
+)
+
+// publish queues a webhook event. The change it reports is already stored, so a
+// failure is only logged.
+func (rt *_router) publish(conversationID, event string, data interface{}) {
+	if err := rt.webhooks.Publish(conversationID, event, data); err != nil {
+		rt.baseLogger.WithError(err).WithField("event", event).Error("error queueing webhook event")
+	}
+}
+
+// publishMessage queues a webhook event carrying a stored message
+func (rt *_router) publishMessage(conversationID, event, messageID string) {
+	response, err := rt.newSenderResponse(messageID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting message")
+		return
+	}
+	rt.publish(conversationID, event, response)
+}
+
+// publishMember queues a membership webhook event
+func (rt *_router) publishMember(conversationID, event, userID, byUserID string) {
+	data := memberEvent{UserID: userID, ByUserID: byUserID}
+	if user, _ := rt.db.GetUserByID(userID); user != nil {
+		data.Username = user.Username
+		data.Bot = user.Bot
+	}
+	rt.publish(conversationID, event, data)
+}
+
+// publishReaction queues a reaction webhook event
+func (rt *_router) publishReaction(conversationID, event, messageID, userID, comment string) {
+	data := reactionEvent{MessageID: messageID, UserID: userID, Comment: comment}
+	if user, _ := rt.db.GetUserByID(userID); user != nil {
+		data.Username = user.Username
+	}
+	rt.publish(conversationID, event, data)
+}
+
+// checkWebhookManager checks that the user administers the conversation, or is a member
+// of it along with one of their bots. On failure it writes an error and returns false.
+func (rt *_router) checkWebhookManager(w http.ResponseWriter, conversationID, userID string) bool {
+	conv, err := rt.db.GetConversation(conversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting conversation")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if conv == nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return false
+	}
+
+	manager, err := rt.db.IsWebhookManager(conversationID, userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error checking webhook manager")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !manager {
+		http.Error(w, "Forbidden - only group admins and bot owners can manage webhooks", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// getConversationWebhook loads a webhook of a conversation, or writes a 404 and returns nil
+func (rt *_router) getConversationWebhook(w http.ResponseWriter, conversationID, webhookID string) *database.Webhook {
+	hook, err := rt.db.GetWebhook(webhookID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if hook == nil || hook.ConversationID != conversationID {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil
+	}
+	return hook
+}
+
+// validWebhookURL reports whether a webhook URL is absolute and uses HTTPS, or plain
+// HTTP when allowed for development. Private addresses given literally are rejected
+// here; host names are checked when connecting, see webhook.NewClient.
+func (rt *_router) validWebhookURL(raw string) bool {
+	if len(raw) > maxWebhookURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return false
+	}
+	if !rt.webhookAllowPrivate {
+		host := u.Hostname()
+		if ip, err := netip.ParseAddr(host); err == nil && webhook.IsPrivateAddress(ip) {
+			return false
+		}
+		if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+			return false
+		}
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && rt.webhookAllowHTTP)
+}
+
+// invalidWebhookURL writes the error for a URL rejected by validWebhookURL
+func (rt *_router) invalidWebhookURL(w http.ResponseWriter) {
+	if rt.webhookAllowHTTP {
+		http.Error(w, "URL must be an absolute HTTP or HTTPS URL to a public address", http.StatusBadRequest)
+	} else {
+		http.Error(w, "URL must be an absolute HTTPS URL to a public address", http.StatusBadRequest)
+	}
+}
+
+// isWebhookEvent reports whether s is an event webhooks can subscribe to
+func isWebhookEvent(s string) bool {
+	for _, event := range webhook.Events {
+		if event == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newWebhookResponse converts a webhook to its API representation, without its secret
+func newWebhookResponse(hook *database.Webhook) webhookResponse {
+	response := webhookResponse{
+		ID:             hook.ID,
+		ConversationID: hook.ConversationID,
+		URL:            hook.URL,
+		Events:         hook.Events,
+		CreatedBy:      hook.CreatedBy,
+		CreatedAt:      hook.CreatedAt,
+	}
+	if response.Events == nil {
+		response.Events = []string{}
+	}
+	return response
+}
+
+// newWebhookDeliveryResponse converts a delivery to its API representation
+func newWebhookDeliveryResponse(d *database.WebhookDelivery) webhookDeliveryResponse {
+	response := webhookDeliveryResponse{
+		ID:             d.ID,
+		Event:          d.Event,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+		Payload:        json.RawMessage(d.Payload),
+	}
+	if d.Status == database.DeliveryPending {
+		response.NextAttemptAt = d.NextAttemptAt
+	}
+	return response
+}
+
+// getWebhooks lists the webhooks of a conversation
+func (rt *_router) getWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	if !rt.checkWebhookManager(w, conversationID, ctx.UserID) {
+		return
+	}
+
+	hooks, err := rt.db.GetWebhooks(conversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting webhooks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]webhookResponse, len(hooks))
+	for i := range hooks {
+		response[i] = newWebhookResponse(&hooks[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// createWebhook registers a webhook for a conversation. Its secret is only returned here.
+func (rt *_router) createWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	if !rt.checkWebhookManager(w, conversationID, ctx.UserID) {
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !rt.validWebhookURL(req.URL) {
+		rt.invalidWebhookURL(w)
+		return
+	}
+	for _, event := range req.Events {
+		if !isWebhookEvent(event) {
+			http.Error(w, "Unknown event", http.StatusBadRequest)
+			return
+		}
+	}
+	if hasDuplicates(req.Events) {
+		http.Error(w, "Duplicate event", http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := rt.db.GetWebhooks(conversationID)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting webhooks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(hooks) >= maxWebhooksPerConversation {
+		http.Error(w, "Too many webhooks for this conversation", http.StatusConflict)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error generating webhook secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hook := database.Webhook{
+		ID:             uuid.New().String(),
+		ConversationID: conversationID,
+		CreatedBy:      ctx.UserID,
+		URL:            req.URL,
+		Secret:         secret,
+		Events:         req.Events,
+	}
+	if err := rt.db.CreateWebhook(&hook); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	created, err := rt.db.GetWebhook(hook.ID)
+	if err != nil || created == nil {
+		rt.baseLogger.WithError(err).Error("error getting webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response := newWebhookResponse(created)
+	response.Secret = created.Secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// deleteWebhook removes a webhook and its delivery log
+func (rt *_router) deleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	if !rt.checkWebhookManager(w, conversationID, ctx.UserID) {
+		return
+	}
+	hook := rt.getConversationWebhook(w, conversationID, ps.ByName("webhookId"))
+	if hook == nil {
+		return
+	}
+
+	if err := rt.db.DeleteWebhook(hook.ID); err != nil {
+		rt.baseLogger.WithError(err).Error("error deleting webhook")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.audit(r, ctx.UserID, audit.ActionWebhookDeleted, hook.ID, audit.Details{"conversation": conversationID, "url": hook.URL})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries lists the latest deliveries of a webhook
+func (rt *_router) getWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	if !rt.checkWebhookManager(w, conversationID, ctx.UserID) {
+		return
+	}
+	hook := rt.getConversationWebhook(w, conversationID, ps.ByName("webhookId"))
+	if hook == nil {
+		return
+	}
+
+	deliveries, err := rt.db.GetWebhookDeliveries(hook.ID, webhookDeliveryLogSize)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting webhook deliveries")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]webhookDeliveryResponse, len(deliveries))
+	for i := range deliveries {
+		response[i] = newWebhookDeliveryResponse(&deliveries[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
+
+// redeliverWebhook queues a new delivery with the payload of an earlier one
+func (rt *_router) redeliverWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationID := ps.ByName("conversationId")
+
+	if !rt.checkWebhookManager(w, conversationID, ctx.UserID) {
+		return
+	}
+	hook := rt.getConversationWebhook(w, conversationID, ps.ByName("webhookId"))
+	if hook == nil {
+		return
+	}
+
+	delivery, err := rt.db.GetWebhookDelivery(ps.ByName("deliveryId"))
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error getting webhook delivery")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil || delivery.WebhookID != hook.ID {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	redelivery := database.WebhookDelivery{
+		ID:        uuid.New().String(),
+		WebhookID: hook.ID,
+		Event:     delivery.Event,
+		Payload:   delivery.Payload,
+	}
+	if err := rt.db.CreateWebhookDeliveries([]database.WebhookDelivery{redelivery}); err != nil {
+		rt.baseLogger.WithError(err).Error("error creating webhook delivery")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rt.webhooks.Wake()
+
+	created, err := rt.db.GetWebhookDelivery(redelivery.ID)
+	if err != nil || created == nil {
+		rt.baseLogger.WithError(err).Error("error getting webhook delivery")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(newWebhookDeliveryResponse(created)); err != nil {
+		rt.baseLogger.WithError(err).Error("error encoding response")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Package audit keeps a tamper-evident trail of security-relevant actions. Entries are
+appended to a table the database refuses to update or delete, and each entry holds the
+SHA-256 hash of its content and of the previous entry, so changing, removing or
+reordering entries breaks the chain from that point on.
+*/
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin           = "session.login"
+	ActionLoginFailed     = "session.login_failed"
+	ActionSessionsRevoked = "session.revoke"
+
+	ActionPasswordSet     = "user.password_set"
+	ActionTOTPEnabled     = "user.totp_enable"
+	ActionTOTPDisabled    = "user.totp_disable"
+	ActionUsernameChanged = "user.rename"
+	ActionAccountDeleted  = "user.delete"
+	ActionDataExported    = "user.export"
+
+	ActionGroupCreated     = "group.create"
+	ActionMemberAdded      = "group.member_add"
+	ActionMemberRemoved    = "group.member_remove"
+	ActionGroupAdminGrant  = "group.admin_grant"
+	ActionGroupAdminRevoke = "group.admin_revoke"
+
+	ActionMessageDeleted         = "message.delete"
+	ActionWebhookDeleted         = "webhook.delete"
+	ActionIncomingWebhookDeleted = "incoming_webhook.delete"
+	ActionAPIKeyRevoked          = "api_key.revoke"
+
+	ActionAdminSuspend        = "admin.suspend"
+	ActionAdminUnsuspend      = "admin.unsuspend"
+	ActionAdminRename         = "admin.rename"
+	ActionAdminGrant          = "admin.grant"
+	ActionAdminRevoke         = "admin.revoke"
+	ActionAdminGroupDelete    = "admin.group_delete"
+	ActionAdminReportDecision = "admin.report_decision"
+)
+
+// TimeFormat is the fixed-width UTC format of entry times, so that they sort as text
+const TimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// genesisHash is the previous hash of the first entry
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Details are the action-specific fields of an entry
+type Details map[string]string
+
+// Entry is an action to record
+type Entry struct {
+	ActorID  string
+	Action   string
+	TargetID string
+	IP       string
+	Details  Details
+}
+
+// Log appends entries to the audit log of a database
+type Log struct {
+	db database.AppDatabase
+	mu sync.Mutex // serializes appends, which read the previous hash
+}
+
+// New returns a Log writing to db
+func New(db database.AppDatabase) *Log {
+	return &Log{db: db}
+}
+
+// Record appends an entry to the log
+func (l *Log) Record(e Entry) error {
+	details := e.Details
+	if details == nil {
+		details = Details{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last, err := l.db.GetLastAuditEntry()
+	if err != nil {
+		return err
+	}
+	entry := database.AuditEntry{
+		Seq:       1,
+		CreatedAt: time.Now().UTC().Format(TimeFormat),
+		ActorID:   e.ActorID,
+		Action:    e.Action,
+		TargetID:  e.TargetID,
+		IP:        e.IP,
+		Details:   string(data),
+		PrevHash:  genesisHash,
+	}
+	if last != nil {
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+	}
+	entry.Hash = Hash(&entry)
+
+	return l.db.AppendAuditEntry(&entry)
+}
+
+// Hash returns the hash of an entry, covering every field but the hash itself
+func Hash(e *database.AuditEntry) string {
+	// A JSON array keeps the fields apart whatever they contain
+	data, _ := json.Marshal([]interface{}{
+		e.Seq, e.CreatedAt, e.ActorID, e.Action, e.TargetID, e.IP, e.Details, e.PrevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// BrokenChainError reports the first entry that doesn't match the chain
+type BrokenChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *BrokenChainError) Error() string {
+	return fmt.Sprintf("audit log broken at entry %d: %s", e.Seq, e.Reason)
+}
+
+// verifyBatchSize is the number of entries read at once while verifying
+const verifyBatchSize = 1000
+
+// Verify checks the whole chain and returns the number of entries and the last entry.
+// A broken chain is reported as a *BrokenChainError. Entries removed from the end of
+// the log can't be detected this way: compare the last hash with one noted earlier.
+func Verify(db database.AppDatabase) (int64, *database.AuditEntry, error) {
+	var count int64
+	var last *database.AuditEntry
+	prevHash, prevSeq := genesisHash, int64(0)
+
+	for {
+		entries, err := db.GetAuditChain(prevSeq, verifyBatchSize)
+		if err != nil {
+			return count, last, err
+		}
+
+		for i := range entries {
+			e := &entries[i]
+			switch {
+			case e.Seq != prevSeq+1:
+				return count, last, &BrokenChainError{Seq: e.Seq, Reason: fmt.Sprintf("entry %d is missing", prevSeq+1)}
+			case e.PrevHash != prevHash:
+				return count, last, &BrokenChainError{Seq: e.Seq, Reason: "previous hash doesn't match"}
+			case e.Hash != Hash(e):
+				return count, last, &BrokenChainError{Seq: e.Seq, Reason: "content doesn't match its hash"}
+			}
+			prevHash, prevSeq = e.Hash, e.Seq
+			last = e
+			count++
+		}
+
+		if len(entries) < verifyBatchSize {
+			return count, last, nil
+		}
+	}
+}
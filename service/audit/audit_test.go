@@ -0,0 +1,244 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sapienzaapps/wasatext/service/database"
+)
+
+// memoryDB keeps the audit log in memory, like the append-only table but open to tampering
+type memoryDB struct {
+	database.AppDatabase
+	entries []database.AuditEntry
+}
+
+func (db *memoryDB) AppendAuditEntry(entry *database.AuditEntry) error {
+	db.entries = append(db.entries, *entry)
+	return nil
+}
+
+func (db *memoryDB) GetLastAuditEntry() (*database.AuditEntry, error) {
+	if len(db.entries) == 0 {
+		return nil, nil
+	}
+	last := db.entries[len(db.entries)-1]
+	return &last, nil
+}
+
+// GetAuditChain returns up to limit stored entries after afterSeq, in the order they are stored
+func (db *memoryDB) GetAuditChain(afterSeq int64, limit int) ([]database.AuditEntry, error) {
+	var entries []database.AuditEntry
+	for _, e := range db.entries {
+		if e.Seq > afterSeq && len(entries) < limit {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// newLog returns a log holding n entries
+func newLog(t *testing.T, n int) *memoryDB {
+	t.Helper()
+	db := &memoryDB{}
+	log := New(db)
+	for i := 0; i < n; i++ {
+		err := log.Record(Entry{
+			ActorID:  fmt.Sprintf("user-%d", i),
+			Action:   ActionLogin,
+			TargetID: "target",
+			IP:       "192.0.2.1",
+			Details:  Details{"n": fmt.Sprint(i)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestRecordChainsEntries(t *testing.T) {
+	db := newLog(t, 3)
+	for i, e := range db.entries {
+		if e.Seq != int64(i+1) {
+			t.Errorf("entry %d has seq %d", i, e.Seq)
+		}
+		if e.Hash != Hash(&e) {
+			t.Errorf("entry %d has a wrong hash", e.Seq)
+		}
+	}
+	if db.entries[0].PrevHash != genesisHash {
+		t.Errorf("first entry follows %s, want the genesis hash", db.entries[0].PrevHash)
+	}
+	for i := 1; i < len(db.entries); i++ {
+		if db.entries[i].PrevHash != db.entries[i-1].Hash {
+			t.Errorf("entry %d doesn't follow entry %d", db.entries[i].Seq, db.entries[i-1].Seq)
+		}
+	}
+	if db.entries[0].Details != `{"n":"0"}` {
+		t.Errorf("details = %s", db.entries[0].Details)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		tamper    func(db *memoryDB)
+		wantSeq   int64 // first broken entry, 0 when the chain is intact
+		wantCount int64 // entries verified before the break
+	}{
+		{
+			name: "empty log",
+		},
+		{
+			name: "intact log",
+			size: 5,
+		},
+		{
+			name: "intact log over several batches",
+			size: 2*verifyBatchSize + 1,
+		},
+		{
+			name: "changed field",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries[2].ActorID = "someone-else"
+			},
+			wantSeq:   3,
+			wantCount: 2,
+		},
+		{
+			name: "changed details",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries[1].Details = `{"n":"9"}`
+			},
+			wantSeq:   2,
+			wantCount: 1,
+		},
+		{
+			name: "changed field with a recomputed hash",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries[2].Action = ActionLoginFailed
+				db.entries[2].Hash = Hash(&db.entries[2])
+			},
+			wantSeq:   4,
+			wantCount: 3,
+		},
+		{
+			name: "changed first entry",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries[0].IP = "198.51.100.1"
+			},
+			wantSeq:   1,
+			wantCount: 0,
+		},
+		{
+			name: "missing entry",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries = append(db.entries[:2], db.entries[3:]...)
+			},
+			wantSeq:   4,
+			wantCount: 2,
+		},
+		{
+			name: "missing first entry",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries = db.entries[1:]
+			},
+			wantSeq:   2,
+			wantCount: 0,
+		},
+		{
+			name: "missing entry renumbered",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries = append(db.entries[:2], db.entries[3:]...)
+				for i := range db.entries {
+					db.entries[i].Seq = int64(i + 1)
+				}
+			},
+			wantSeq:   3,
+			wantCount: 2,
+		},
+		{
+			name: "reordered entries",
+			size: 5,
+			tamper: func(db *memoryDB) {
+				db.entries[1], db.entries[2] = db.entries[2], db.entries[1]
+				db.entries[1].Seq, db.entries[2].Seq = 2, 3
+			},
+			wantSeq:   2,
+			wantCount: 1,
+		},
+		{
+			name: "broken in a later batch",
+			size: verifyBatchSize + 10,
+			tamper: func(db *memoryDB) {
+				db.entries[verifyBatchSize+4].TargetID = "other"
+			},
+			wantSeq:   verifyBatchSize + 5,
+			wantCount: verifyBatchSize + 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newLog(t, tt.size)
+			if tt.tamper != nil {
+				tt.tamper(db)
+			}
+
+			count, last, err := Verify(db)
+
+			if tt.wantSeq == 0 {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if count != int64(tt.size) {
+					t.Errorf("count = %d, want %d", count, tt.size)
+				}
+				if tt.size == 0 && last != nil {
+					t.Errorf("last = %+v, want nil", last)
+				}
+				if tt.size > 0 && (last == nil || last.Seq != int64(tt.size)) {
+					t.Errorf("last = %+v, want entry %d", last, tt.size)
+				}
+				return
+			}
+
+			var broken *BrokenChainError
+			if !errors.As(err, &broken) {
+				t.Fatalf("Verify error = %v, want a broken chain", err)
+			}
+			if broken.Seq != tt.wantSeq {
+				t.Errorf("broken at entry %d (%s), want %d", broken.Seq, broken.Reason, tt.wantSeq)
+			}
+			if count != tt.wantCount {
+				t.Errorf("count = %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestVerifyReportsDatabaseErrors(t *testing.T) {
+	want := errors.New("disk failure")
+	_, _, err := Verify(&failingDB{err: want})
+	if !errors.Is(err, want) {
+		t.Errorf("Verify error = %v, want %v", err, want)
+	}
+}
+
+type failingDB struct {
+	database.AppDatabase
+	err error
+}
+
+func (db *failingDB) GetAuditChain(int64, int) ([]database.AuditEntry, error) {
+	return nil, db.err
+}
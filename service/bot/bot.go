@@ -0,0 +1,115 @@
+/*
+Package bot defines how bots answer slash commands and presses of the inline buttons
+of their messages. A bot is served either by a Handler compiled into the server or by
+a WebhookHandler forwarding events to the bot's own HTTPS endpoint.
+*/
+package bot
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of a reply
+const (
+	MaxTextLength       = 4096
+	MaxButtonRows       = 8
+	MaxButtonsPerRow    = 5
+	MaxButtonTextLength = 32
+	MaxButtonDataLength = 64
+)
+
+// MaxCommandLength bounds the name of a command, without its slash
+const MaxCommandLength = 32
+
+// commandPattern matches "/name" or "/name@bot" at the start of a message
+var commandPattern = regexp.MustCompile(`^/([a-z0-9_]{1,32})(?:@([a-zA-Z0-9_]+))?(?:\s+|$)`)
+
+// Command is a slash command sent in a conversation the bot is a member of
+type Command struct {
+	BotID          string `json:"botId"`
+	ConversationID string `json:"conversationId"`
+	MessageID      string `json:"messageId"`
+	UserID         string `json:"userId"`
+	Username       string `json:"username"`
+	Name           string `json:"command"` // without the slash
+	Args           string `json:"args"`    // the rest of the message
+}
+
+// Callback is the press of an inline button of a message sent by the bot
+type Callback struct {
+	BotID          string `json:"botId"`
+	ConversationID string `json:"conversationId"`
+	MessageID      string `json:"messageId"`
+	UserID         string `json:"userId"`
+	Username       string `json:"username"`
+	Data           string `json:"data"` // data of the pressed button
+}
+
+// Button is an inline button. Its data is sent back to the bot when it is pressed
+// and never shown to users.
+type Button struct {
+	Text string `json:"text"`
+	Data string `json:"data"`
+}
+
+// Reply is a message sent by a bot: the answer to a command, or the new content of
+// the message whose button was pressed
+type Reply struct {
+	Text    string     `json:"text"`
+	Buttons [][]Button `json:"buttons,omitempty"` // rows of buttons
+}
+
+// Handler answers the commands and button presses of a bot. A nil reply sends nothing,
+// or leaves the message whose button was pressed unchanged.
+type Handler interface {
+	HandleCommand(ctx context.Context, cmd Command) (*Reply, error)
+	HandleCallback(ctx context.Context, cb Callback) (*Reply, error)
+}
+
+// ParseCommand splits a message into a command name, the bot it is addressed to
+// ("" for any bot) and its arguments. ok is false for messages that are not commands.
+func ParseCommand(text string) (name, botUsername, args string, ok bool) {
+	m := commandPattern.FindStringSubmatchIndex(text)
+	if m == nil {
+		return "", "", "", false
+	}
+	name = text[m[2]:m[3]]
+	if m[4] >= 0 {
+		botUsername = text[m[4]:m[5]]
+	}
+	return name, botUsername, strings.TrimSpace(text[m[1]:]), true
+}
+
+// ValidCommandName reports whether name can be registered as a command
+func ValidCommandName(name string) bool {
+	m := commandPattern.FindStringSubmatch("/" + name)
+	return m != nil && m[1] == name
+}
+
+// Validate checks that a reply can be stored as a message
+func (r *Reply) Validate() error {
+	if strings.TrimSpace(r.Text) == "" || utf8.RuneCountInString(r.Text) > MaxTextLength {
+		return errors.New("reply text must be 1-4096 characters")
+	}
+	if len(r.Buttons) > MaxButtonRows {
+		return errors.New("too many rows of buttons")
+	}
+	for _, row := range r.Buttons {
+		if len(row) == 0 || len(row) > MaxButtonsPerRow {
+			return errors.New("a row must have 1-5 buttons")
+		}
+		for _, b := range row {
+			if strings.TrimSpace(b.Text) == "" || utf8.RuneCountInString(b.Text) > MaxButtonTextLength {
+				return errors.New("button text must be 1-32 characters")
+			}
+			if len(b.Data) > MaxButtonDataLength {
+				return errors.New("button data must be at most 64 bytes")
+			}
+		}
+	}
+	return nil
+}
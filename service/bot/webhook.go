@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sapienzaapps/wasatext/service/webhook"
+)
+
+// Events sent to a bot endpoint
+const (
+	EventCommand  = "bot.command"
+	EventCallback = "bot.callback"
+)
+
+// maxReplySize bounds the reply read from a bot endpoint
+const maxReplySize = 64 << 10
+
+// WebhookHandler serves a bot by posting its events to the bot's endpoint. Requests are
+// signed like webhook deliveries. The endpoint answers 200 with a JSON Reply, or 204
+// for no reply.
+type WebhookHandler struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// HandleCommand posts a command to the endpoint
+func (h *WebhookHandler) HandleCommand(ctx context.Context, cmd Command) (*Reply, error) {
+	return h.post(ctx, EventCommand, cmd)
+}
+
+// HandleCallback posts a button press to the endpoint
+func (h *WebhookHandler) HandleCallback(ctx context.Context, cb Callback) (*Reply, error) {
+	return h.post(ctx, EventCallback, cb)
+}
+
+func (h *WebhookHandler) post(ctx context.Context, event string, data interface{}) (*Reply, error) {
+	body, err := json.Marshal(struct {
+		Event string      `json:"event"`
+		Data  interface{} `json:"data"`
+	}{event, data})
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WASAText-Bot")
+	req.Header.Set(webhook.HeaderEvent, event)
+	req.Header.Set(webhook.HeaderDelivery, uuid.NewString())
+	req.Header.Set(webhook.HeaderTimestamp, timestamp)
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(h.Secret, timestamp, body))
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var reply Reply
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxReplySize)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	return &reply, nil
+}
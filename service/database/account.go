@@ -0,0 +1,142 @@
+package database
+
+import "database/sql"
+
+// DeleteUser deletes an account with the bots it owns. Their messages stay in the
+// conversations they share with others; conversations left without members are
+// deleted. Reports and the audit log keep referring to the account by ID.
+func (db *appdbimpl) DeleteUser(userID string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	accounts, err := queryStrings(tx, "SELECT id FROM users WHERE owner_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	// Bots first, so that conversations shared only with their owner go too
+	accounts = append(accounts, userID)
+
+	for _, id := range accounts {
+		if err := deleteAccount(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deleteAccount deletes one account within a transaction
+func deleteAccount(tx *sql.Tx, id string) error {
+	orphaned, err := queryStrings(tx, `
+		SELECT conversation_id FROM conversation_members
+		WHERE user_id = ? AND conversation_id NOT IN (
+			SELECT conversation_id FROM conversation_members WHERE user_id != ?
+		)
+	`, id, id)
+	if err != nil {
+		return err
+	}
+	for _, conversationID := range orphaned {
+		if err := deleteConversation(tx, conversationID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM blocks WHERE blocker_id = ? OR blocked_id = ?", id, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM declined_requests WHERE user_id = ? OR sender_id = ?", id, id); err != nil {
+		return err
+	}
+
+	administered, err := queryStrings(tx, "SELECT conversation_id FROM conversation_members WHERE user_id = ? AND admin = 1", id)
+	if err != nil {
+		return err
+	}
+
+	stmts := []string{
+		"DELETE FROM message_comments WHERE user_id = ?",
+		"DELETE FROM poll_votes WHERE user_id = ?",
+		"DELETE FROM conversation_members WHERE user_id = ?",
+		"DELETE FROM privacy_settings WHERE user_id = ?",
+		"DELETE FROM passwords WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM totp WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM oidc_identities WHERE user_id = ?",
+		"DELETE FROM api_key_conversations WHERE key_id IN (SELECT id FROM api_keys WHERE bot_id = ?)",
+		"DELETE FROM api_keys WHERE bot_id = ?",
+		"DELETE FROM bot_endpoints WHERE bot_id = ?",
+		"DELETE FROM bot_commands WHERE bot_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE created_by = ?)",
+		"DELETE FROM webhooks WHERE created_by = ?",
+		"DELETE FROM incoming_webhooks WHERE created_by = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+
+	for _, groupID := range administered {
+		if err := handOverGroupAdmin(tx, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserMessageIDs lists the messages sent by a user, oldest first
+func (db *appdbimpl) GetUserMessageIDs(userID string) ([]string, error) {
+	return queryStrings(db.c, "SELECT id FROM messages WHERE sender_id = ? ORDER BY created_at, id", userID)
+}
+
+// GetUserComments lists the reactions left by a user
+func (db *appdbimpl) GetUserComments(userID string) ([]Comment, error) {
+	rows, err := db.c.Query(`
+		SELECT mc.message_id, mc.user_id, u.username, mc.comment
+		FROM message_comments mc
+		INNER JOIN users u ON mc.user_id = u.id
+		WHERE mc.user_id = ?
+		ORDER BY mc.message_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.MessageID, &c.UserID, &c.Username, &c.Comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// queryStrings runs a query returning one text column
+func queryStrings(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,121 @@
+package database
+
+// ListUsers returns the users whose username contains query, all users when it is
+// empty, sorted by username
+func (db *appdbimpl) ListUsers(query string, limit, offset int) ([]User, error) {
+	rows, err := db.c.Query(`
+		SELECT `+userColumns+` FROM users
+		WHERE username LIKE ?
+		ORDER BY username
+		LIMIT ? OFFSET ?
+	`, "%"+query+"%", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// SetUserAdmin grants or revokes the server admin role
+func (db *appdbimpl) SetUserAdmin(userID string, admin bool) error {
+	_, err := db.c.Exec("UPDATE users SET admin = ? WHERE id = ?", admin, userID)
+	return err
+}
+
+// RevokePasswordlessAdmins takes the admin role away from admins without a password
+// and returns them
+func (db *appdbimpl) RevokePasswordlessAdmins() ([]User, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query(`
+		SELECT ` + userColumns + ` FROM users
+		WHERE admin = 1 AND id NOT IN (SELECT user_id FROM passwords)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if _, err := tx.Exec("UPDATE users SET admin = 0 WHERE id = ?", user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return users, tx.Commit()
+}
+
+// SuspendUser suspends an account and ends its sessions. Suspending a suspended
+// account keeps the original date.
+func (db *appdbimpl) SuspendUser(userID string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("UPDATE users SET suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP) WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnsuspendUser lifts the suspension of an account
+func (db *appdbimpl) UnsuspendUser(userID string) error {
+	_, err := db.c.Exec("UPDATE users SET suspended_at = NULL WHERE id = ?", userID)
+	return err
+}
+
+// GetServerStats counts the users, conversations and messages of the server and the
+// bytes they take
+func (db *appdbimpl) GetServerStats() (*ServerStats, error) {
+	var stats ServerStats
+	err := db.c.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE bot = 0),
+			(SELECT COUNT(*) FROM users WHERE bot = 1),
+			(SELECT COUNT(*) FROM users WHERE admin = 1),
+			(SELECT COUNT(*) FROM users WHERE suspended_at IS NOT NULL),
+			(SELECT COUNT(*) FROM conversations WHERE type = 'group'),
+			(SELECT COUNT(*) FROM conversations WHERE type = 'private'),
+			(SELECT COUNT(*) FROM messages),
+			COALESCE((SELECT SUM(LENGTH(photo)) FROM users), 0) +
+				COALESCE((SELECT SUM(LENGTH(photo)) FROM conversations), 0) +
+				COALESCE((SELECT SUM(`+messageSizeExpr+`) FROM messages m), 0),
+			(SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size())
+	`).Scan(&stats.Users, &stats.Bots, &stats.Admins, &stats.SuspendedUsers, &stats.Groups,
+		&stats.PrivateChats, &stats.Messages, &stats.MediaBytes, &stats.DatabaseBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
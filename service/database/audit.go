@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const auditColumns = "seq, created_at, actor_id, action, target_id, ip, details, prev_hash, hash"
+
+// AppendAuditEntry adds an entry at the end of the audit log. The entry must carry the
+// next sequence number and its hashes, computed by the writer.
+func (db *appdbimpl) AppendAuditEntry(entry *AuditEntry) error {
+	_, err := db.c.Exec("INSERT INTO audit_log ("+auditColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Seq, entry.CreatedAt, entry.ActorID, entry.Action, entry.TargetID, entry.IP,
+		entry.Details, entry.PrevHash, entry.Hash)
+	return err
+}
+
+// GetLastAuditEntry retrieves the newest entry of the audit log, nil if it is empty
+func (db *appdbimpl) GetLastAuditEntry() (*AuditEntry, error) {
+	entry, err := scanAuditEntry(db.c.QueryRow("SELECT " + auditColumns + " FROM audit_log ORDER BY seq DESC LIMIT 1"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return entry, err
+}
+
+// GetAuditEntries lists the audit log entries matching filter, newest first
+func (db *appdbimpl) GetAuditEntries(filter AuditFilter, limit int) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	for _, c := range []struct {
+		condition string
+		value     string
+	}{
+		{"actor_id = ?", filter.ActorID},
+		{"action = ?", filter.Action},
+		{"target_id = ?", filter.TargetID},
+		{"created_at >= ?", filter.Since},
+		{"created_at < ?", filter.Until},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.condition)
+			args = append(args, c.value)
+		}
+	}
+	if filter.BeforeSeq > 0 {
+		conditions = append(conditions, "seq < ?")
+		args = append(args, filter.BeforeSeq)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY seq DESC LIMIT ?"
+	args = append(args, limit)
+
+	return db.queryAuditEntries(query, args...)
+}
+
+// GetAuditChain lists the audit log entries after afterSeq, oldest first, to verify the chain
+func (db *appdbimpl) GetAuditChain(afterSeq int64, limit int) ([]AuditEntry, error) {
+	return db.queryAuditEntries("SELECT "+auditColumns+" FROM audit_log WHERE seq > ? ORDER BY seq LIMIT ?", afterSeq, limit)
+}
+
+func (db *appdbimpl) queryAuditEntries(query string, args ...interface{}) ([]AuditEntry, error) {
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// scanAuditEntry reads a row of auditColumns
+func scanAuditEntry(row interface{ Scan(...interface{}) error }) (*AuditEntry, error) {
+	var e AuditEntry
+	err := row.Scan(&e.Seq, &e.CreatedAt, &e.ActorID, &e.Action, &e.TargetID, &e.IP, &e.Details, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+)
+
+// BlockUser records that blockerID blocked blockedID; blocking twice is not an error
+func (db *appdbimpl) BlockUser(blockerID, blockedID string) error {
+	_, err := db.c.Exec(`
+		INSERT OR IGNORE INTO blocks (blocker_id, blocked_id, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, blockerID, blockedID)
+	return err
+}
+
+// UnblockUser removes a block
+func (db *appdbimpl) UnblockUser(blockerID, blockedID string) error {
+	result, err := db.c.Exec("DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("block not found")
+	}
+	return nil
+}
+
+// IsBlocked checks whether blockerID blocked blockedID
+func (db *appdbimpl) IsBlocked(blockerID, blockedID string) (bool, error) {
+	var blocked bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?)",
+		blockerID, blockedID).Scan(&blocked)
+	return blocked, err
+}
+
+// IsBlockedInPrivateConversation checks whether the other member of a private conversation blocked userID.
+// It is always false for groups.
+func (db *appdbimpl) IsBlockedInPrivateConversation(conversationID, userID string) (bool, error) {
+	var blocked bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM conversations c
+			JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id != ?
+			JOIN blocks b ON b.blocker_id = cm.user_id AND b.blocked_id = ?
+			WHERE c.id = ? AND c.type = 'private'
+		)
+	`, userID, userID, conversationID).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockedUsers retrieves the users blocked by blockerID, most recently blocked first
+func (db *appdbimpl) GetBlockedUsers(blockerID string) ([]User, error) {
+	rows, err := db.c.Query(`
+		SELECT u.id, u.username, u.photo, u.bot
+		FROM blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC, b.rowid DESC
+	`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Photo, &user.Bot); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
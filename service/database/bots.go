@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// CreateBot creates a bot user owned by ownerID
+func (db *appdbimpl) CreateBot(id, username, ownerID string) error {
+	_, err := db.c.Exec("INSERT INTO users (id, username, bot, owner_id) VALUES (?, ?, 1, ?)", id, username, ownerID)
+	return err
+}
+
+// GetBots retrieves the bots created by a user
+func (db *appdbimpl) GetBots(ownerID string) ([]User, error) {
+	rows, err := db.c.Query(`
+		SELECT id, username, photo, bot, owner_id FROM users
+		WHERE bot = 1 AND owner_id = ?
+		ORDER BY username
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Photo, &user.Bot, &user.OwnerID); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// CreateAPIKey stores a new key of a bot by the hash of its secret
+func (db *appdbimpl) CreateAPIKey(key *APIKey, keyHash string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		INSERT INTO api_keys (id, bot_id, name, key_hash, prefix, scopes)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, key.ID, key.BotID, key.Name, keyHash, key.Prefix, strings.Join(key.Scopes, " "))
+	if err != nil {
+		return err
+	}
+	for _, conversationID := range key.ConversationIDs {
+		if _, err := tx.Exec("INSERT INTO api_key_conversations (key_id, conversation_id) VALUES (?, ?)", key.ID, conversationID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetAPIKeys retrieves the keys of a bot that were not revoked, newest first
+func (db *appdbimpl) GetAPIKeys(botID string) ([]APIKey, error) {
+	rows, err := db.c.Query(`
+		SELECT id, bot_id, name, prefix, scopes, created_at, last_used_at
+		FROM api_keys WHERE bot_id = ? AND revoked_at IS NULL
+		ORDER BY created_at DESC, rowid DESC
+	`, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if keys[i].ConversationIDs, err = db.getAPIKeyConversations(keys[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash retrieves the key with a secret hash, or nil if there is none or it was revoked
+func (db *appdbimpl) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.c.QueryRow(`
+		SELECT id, bot_id, name, prefix, scopes, created_at, last_used_at
+		FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL
+	`, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.ConversationIDs, err = db.getAPIKeyConversations(key.ID); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// MarkAPIKeyUsed records that a key was just used
+func (db *appdbimpl) MarkAPIKeyUsed(id string) error {
+	_, err := db.c.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// RevokeAPIKey stops a key of a bot from working
+func (db *appdbimpl) RevokeAPIKey(botID, keyID string) error {
+	result, err := db.c.Exec(`
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND bot_id = ? AND revoked_at IS NULL
+	`, keyID, botID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("api key not found")
+	}
+	return nil
+}
+
+// scanAPIKey scans the columns selected by GetAPIKeys and GetAPIKeyByHash
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var lastUsedAt sql.NullString
+	if err := row.Scan(&key.ID, &key.BotID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = lastUsedAt.String
+	}
+	return &key, nil
+}
+
+func (db *appdbimpl) getAPIKeyConversations(keyID string) ([]string, error) {
+	rows, err := db.c.Query("SELECT conversation_id FROM api_key_conversations WHERE key_id = ?", keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversationIDs []string
+	for rows.Next() {
+		var conversationID string
+		if err := rows.Scan(&conversationID); err != nil {
+			return nil, err
+		}
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	return conversationIDs, rows.Err()
+}
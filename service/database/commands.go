@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SetBotEndpoint sets the URL a bot receives its commands and button presses at
+func (db *appdbimpl) SetBotEndpoint(botID, url, secret string) error {
+	_, err := db.c.Exec(`
+		INSERT INTO bot_endpoints (bot_id, url, secret) VALUES (?, ?, ?)
+		ON CONFLICT(bot_id) DO UPDATE SET url = excluded.url, secret = excluded.secret, updated_at = CURRENT_TIMESTAMP
+	`, botID, url, secret)
+	return err
+}
+
+// GetBotEndpoint retrieves the endpoint of a bot, nil if it has none
+func (db *appdbimpl) GetBotEndpoint(botID string) (*BotEndpoint, error) {
+	var endpoint BotEndpoint
+	err := db.c.QueryRow("SELECT bot_id, url, secret FROM bot_endpoints WHERE bot_id = ?", botID).
+		Scan(&endpoint.BotID, &endpoint.URL, &endpoint.Secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// DeleteBotEndpoint removes the endpoint of a bot
+func (db *appdbimpl) DeleteBotEndpoint(botID string) error {
+	_, err := db.c.Exec("DELETE FROM bot_endpoints WHERE bot_id = ?", botID)
+	return err
+}
+
+// SetBotCommands replaces the commands a bot answers
+func (db *appdbimpl) SetBotCommands(botID string, commands []BotCommand) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM bot_commands WHERE bot_id = ?", botID); err != nil {
+		return err
+	}
+	for _, c := range commands {
+		_, err := tx.Exec("INSERT INTO bot_commands (bot_id, command, description) VALUES (?, ?, ?)",
+			botID, c.Command, c.Description)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetBotCommands retrieves the commands a bot answers, by name
+func (db *appdbimpl) GetBotCommands(botID string) ([]BotCommand, error) {
+	return db.queryBotCommands(`
+		SELECT bc.bot_id, u.username, bc.command, bc.description
+		FROM bot_commands bc JOIN users u ON u.id = bc.bot_id
+		WHERE bc.bot_id = ?
+		ORDER BY bc.command
+	`, botID)
+}
+
+// GetConversationCommands retrieves the commands of the bots in a conversation, by name
+func (db *appdbimpl) GetConversationCommands(conversationID string) ([]BotCommand, error) {
+	return db.queryBotCommands(`
+		SELECT bc.bot_id, u.username, bc.command, bc.description
+		FROM bot_commands bc
+		JOIN users u ON u.id = bc.bot_id
+		JOIN conversation_members cm ON cm.user_id = bc.bot_id
+		WHERE cm.conversation_id = ? AND cm.pending = 0
+		ORDER BY bc.command, u.username
+	`, conversationID)
+}
+
+// UpdateBotMessage replaces the text and buttons of a message sent by a bot
+func (db *appdbimpl) UpdateBotMessage(id, content string, buttons [][]MessageButton) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE messages SET content = ? WHERE id = ?", content, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM message_buttons WHERE message_id = ?", id); err != nil {
+		return err
+	}
+	if err := insertButtons(tx, id, buttons); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *appdbimpl) queryBotCommands(query string, args ...interface{}) ([]BotCommand, error) {
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []BotCommand
+	for rows.Next() {
+		var c BotCommand
+		if err := rows.Scan(&c.BotID, &c.BotUsername, &c.Command, &c.Description); err != nil {
+			return nil, err
+		}
+		commands = append(commands, c)
+	}
+	return commands, rows.Err()
+}
+
+// insertButtons stores the inline buttons of a message
+func insertButtons(tx *sql.Tx, messageID string, buttons [][]MessageButton) error {
+	for row, buttonRow := range buttons {
+		for position, b := range buttonRow {
+			_, err := tx.Exec(`
+				INSERT INTO message_buttons (message_id, row, position, text, data) VALUES (?, ?, ?, ?, ?)
+			`, messageID, row, position, b.Text, b.Data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getConversationButtons loads the inline buttons of the messages of a conversation, by message ID
+func (db *appdbimpl) getConversationButtons(conversationID string) (map[string][][]MessageButton, error) {
+	return db.queryButtons(`
+		SELECT mb.message_id, mb.row, mb.text, mb.data
+		FROM message_buttons mb JOIN messages m ON m.id = mb.message_id
+		WHERE m.conversation_id = ?
+		ORDER BY mb.message_id, mb.row, mb.position
+	`, conversationID)
+}
+
+// getMessageButtons loads the inline buttons of a message
+func (db *appdbimpl) getMessageButtons(messageID string) ([][]MessageButton, error) {
+	buttons, err := db.queryButtons(`
+		SELECT message_id, row, text, data FROM message_buttons
+		WHERE message_id = ?
+		ORDER BY row, position
+	`, messageID)
+	return buttons[messageID], err
+}
+
+func (db *appdbimpl) queryButtons(query string, args ...interface{}) (map[string][][]MessageButton, error) {
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buttons := make(map[string][][]MessageButton)
+	for rows.Next() {
+		var messageID string
+		var row int
+		var b MessageButton
+		if err := rows.Scan(&messageID, &row, &b.Text, &b.Data); err != nil {
+			return nil, err
+		}
+		for len(buttons[messageID]) <= row {
+			buttons[messageID] = append(buttons[messageID], nil)
+		}
+		buttons[messageID][row] = append(buttons[messageID][row], b)
+	}
+	return buttons, rows.Err()
+}
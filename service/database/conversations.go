@@ -5,8 +5,9 @@ import (
 	"errors"
 )
 
-// CreatePrivateConversation creates a private conversation between two users
-func (db *appdbimpl) CreatePrivateConversation(id, user1ID, user2ID string) error {
+// CreatePrivateConversation creates a private conversation between two users. As a request,
+// it stays in user2's message requests until they accept it.
+func (db *appdbimpl) CreatePrivateConversation(id, user1ID, user2ID string, asRequest bool) error {
 	tx, err := db.c.Begin()
 	if err != nil {
 		return err
@@ -18,8 +19,8 @@ func (db *appdbimpl) CreatePrivateConversation(id, user1ID, user2ID string) erro
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?), (?, ?)",
-		id, user1ID, id, user2ID)
+	_, err = tx.Exec("INSERT INTO conversation_members (conversation_id, user_id, pending) VALUES (?, ?, 0), (?, ?, ?)",
+		id, user1ID, id, user2ID, asRequest)
 	if err != nil {
 		return err
 	}
@@ -40,7 +41,8 @@ func (db *appdbimpl) CreateGroupConversation(id, name, creatorID string) error {
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?)", id, creatorID)
+	// The creator administers the group
+	_, err = tx.Exec("INSERT INTO conversation_members (conversation_id, user_id, admin) VALUES (?, ?, 1)", id, creatorID)
 	if err != nil {
 		return err
 	}
@@ -51,14 +53,17 @@ func (db *appdbimpl) CreateGroupConversation(id, name, creatorID string) error {
 // GetConversation retrieves a conversation by ID
 func (db *appdbimpl) GetConversation(id string) (*Conversation, error) {
 	var conv Conversation
+	var groupName sql.NullString
 	err := db.c.QueryRow("SELECT id, type, group_name, photo FROM conversations WHERE id = ?", id).
-		Scan(&conv.ID, &conv.Type, &conv.GroupName, &conv.Photo)
+		Scan(&conv.ID, &conv.Type, &groupName, &conv.Photo)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	// Private conversations have no name
+	conv.GroupName = groupName.String
 	return &conv, nil
 }
 
@@ -72,33 +77,50 @@ func (db *appdbimpl) GetPrivateConversation(user1ID, user2ID string) (*Conversat
 		WHERE c.type = 'private'
 	`
 	var conv Conversation
-	err := db.c.QueryRow(query, user1ID, user2ID).Scan(&conv.ID, &conv.Type, &conv.GroupName, &conv.Photo)
+	var groupName sql.NullString
+	err := db.c.QueryRow(query, user1ID, user2ID).Scan(&conv.ID, &conv.Type, &groupName, &conv.Photo)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	// Private conversations have no name
+	conv.GroupName = groupName.String
 	return &conv, nil
 }
 
-// GetUserConversations retrieves all conversations for a user
+// GetUserConversations retrieves all conversations for a user, except pending message requests
 func (db *appdbimpl) GetUserConversations(userID string) ([]ConversationPreview, error) {
+	return db.getConversationPreviews(userID, false)
+}
+
+// GetMessageRequests retrieves the private conversations a user has not accepted yet
+func (db *appdbimpl) GetMessageRequests(userID string) ([]ConversationPreview, error) {
+	return db.getConversationPreviews(userID, true)
+}
+
+// getConversationPreviews lists the conversations of a user that are pending or not, newest activity first
+func (db *appdbimpl) getConversationPreviews(userID string, pending bool) ([]ConversationPreview, error) {
 	query := `
 		SELECT c.id, c.type, c.group_name, c.photo,
 			COALESCE(m.content, '') as latest_content,
+			COALESCE(m.type, '') as latest_type,
+			COALESCE(m.caption, '') as latest_caption,
+			COALESCE(m.alt_text, '') as latest_alt_text,
+			COALESCE(m.file_name, '') as latest_file_name,
 			COALESCE(m.created_at, '') as latest_timestamp,
 			COALESCE(m.sender_id, '') as latest_sender
 		FROM conversations c
-		INNER JOIN conversation_members cm ON c.id = cm.conversation_id AND cm.user_id = ?
+		INNER JOIN conversation_members cm ON c.id = cm.conversation_id AND cm.user_id = ? AND cm.pending = ?
 		LEFT JOIN (
-			SELECT conversation_id, content, created_at, sender_id,
+			SELECT conversation_id, content, type, caption, alt_text, file_name, created_at, sender_id,
 				ROW_NUMBER() OVER (PARTITION BY conversation_id ORDER BY created_at DESC) as rn
 			FROM messages
 		) m ON c.id = m.conversation_id AND m.rn = 1
 		ORDER BY m.created_at DESC NULLS LAST
 	`
-	rows, err := db.c.Query(query, userID)
+	rows, err := db.c.Query(query, userID, pending)
 	if err != nil {
 		return nil, err
 	}
@@ -108,10 +130,10 @@ func (db *appdbimpl) GetUserConversations(userID string) ([]ConversationPreview,
 	for rows.Next() {
 		var p ConversationPreview
 		var groupName sql.NullString
-		var latestContent, latestTimestamp, latestSender string
+		var latestContent, latestType, latestCaption, latestAltText, latestFileName, latestTimestamp, latestSender string
 
 		if err := rows.Scan(&p.ID, &p.Type, &groupName, &p.Photo,
-			&latestContent, &latestTimestamp, &latestSender); err != nil {
+			&latestContent, &latestType, &latestCaption, &latestAltText, &latestFileName, &latestTimestamp, &latestSender); err != nil {
 			return nil, err
 		}
 
@@ -122,6 +144,10 @@ func (db *appdbimpl) GetUserConversations(userID string) ([]ConversationPreview,
 		if latestContent != "" || latestTimestamp != "" {
 			p.LatestMessage = &MessagePreview{
 				Content:   latestContent,
+				Type:      latestType,
+				Caption:   latestCaption,
+				AltText:   latestAltText,
+				FileName:  latestFileName,
 				Timestamp: latestTimestamp,
 				SenderID:  latestSender,
 			}
@@ -146,11 +172,11 @@ func (db *appdbimpl) GetUserConversations(userID string) ([]ConversationPreview,
 func (db *appdbimpl) getOtherUserInConversation(conversationID, userID string) (*User, error) {
 	var user User
 	err := db.c.QueryRow(`
-		SELECT u.id, u.username, u.photo 
+		SELECT u.id, u.username, u.photo, u.bot
 		FROM users u
 		INNER JOIN conversation_members cm ON u.id = cm.user_id
 		WHERE cm.conversation_id = ? AND u.id != ?
-	`, conversationID, userID).Scan(&user.ID, &user.Username, &user.Photo)
+	`, conversationID, userID).Scan(&user.ID, &user.Username, &user.Photo, &user.Bot)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -178,15 +204,135 @@ func (db *appdbimpl) MarkConversationRead(conversationID, userID string) error {
 	return err
 }
 
+// SharesConversation checks whether two users are both members of any conversation, not counting
+// message requests that are still pending
+func (db *appdbimpl) SharesConversation(user1ID, user2ID string) (bool, error) {
+	var shares bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM conversation_members cm1
+			INNER JOIN conversation_members cm2 ON cm1.conversation_id = cm2.conversation_id
+			WHERE cm1.user_id = ? AND cm2.user_id = ? AND cm1.pending = 0 AND cm2.pending = 0
+		)
+	`, user1ID, user2ID).Scan(&shares)
+	return shares, err
+}
+
+// IsMessageRequest checks whether a conversation is waiting for userID to accept it
+func (db *appdbimpl) IsMessageRequest(conversationID, userID string) (bool, error) {
+	var pending bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ? AND pending = 1)",
+		conversationID, userID).Scan(&pending)
+	return pending, err
+}
+
+// AcceptMessageRequest moves a message request to the user's conversations
+func (db *appdbimpl) AcceptMessageRequest(conversationID, userID string) error {
+	result, err := db.c.Exec("UPDATE conversation_members SET pending = 0 WHERE conversation_id = ? AND user_id = ? AND pending = 1",
+		conversationID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("message request not found")
+	}
+	return nil
+}
+
+// DeclineMessageRequest deletes a message request with its messages, recording that userID
+// declined it so the sender can be kept from sending a new one right away
+func (db *appdbimpl) DeclineMessageRequest(conversationID, userID string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO declined_requests (user_id, sender_id, declined_at)
+		SELECT ?, user_id, CURRENT_TIMESTAMP FROM conversation_members WHERE conversation_id = ? AND user_id != ?
+	`, userID, conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteConversation(tx, conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeclinedRequestSince checks whether userID declined a message request from senderID after
+// the given time
+func (db *appdbimpl) DeclinedRequestSince(userID, senderID, since string) (bool, error) {
+	var declined bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM declined_requests WHERE user_id = ? AND sender_id = ? AND declined_at > datetime(?))",
+		userID, senderID, since).Scan(&declined)
+	return declined, err
+}
+
+// DeleteConversation deletes a conversation with its members and all its messages
+func (db *appdbimpl) DeleteConversation(id string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := deleteConversation(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteConversation deletes a conversation within a transaction
+func deleteConversation(tx *sql.Tx, id string) error {
+	// Records attached to the messages first, then the messages and the conversation itself
+	for _, table := range []string{"message_comments", "message_media", "message_buttons", "poll_votes", "poll_options", "polls", "locations"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmts := []string{
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE conversation_id = ?)",
+		"DELETE FROM webhooks WHERE conversation_id = ?",
+		"DELETE FROM incoming_webhooks WHERE conversation_id = ?",
+		"DELETE FROM messages WHERE conversation_id = ?",
+		"DELETE FROM conversation_members WHERE conversation_id = ?",
+		"DELETE FROM conversations WHERE id = ?",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // AddGroupMember adds a user to a group
 func (db *appdbimpl) AddGroupMember(groupID, userID string) error {
 	_, err := db.c.Exec("INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?)", groupID, userID)
 	return err
 }
 
-// RemoveGroupMember removes a user from a group
+// RemoveGroupMember removes a user from a group. When the last admin leaves, the
+// role passes to the longest-standing member. The webhooks of the user, and of the
+// owner of a removed bot, are deleted once they can no longer manage them, and so are
+// the incoming webhooks of the user.
 func (db *appdbimpl) RemoveGroupMember(groupID, userID string) error {
-	result, err := db.c.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", groupID, userID)
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", groupID, userID)
 	if err != nil {
 		return err
 	}
@@ -197,7 +343,82 @@ func (db *appdbimpl) RemoveGroupMember(groupID, userID string) error {
 	if rowsAffected == 0 {
 		return errors.New("user not a member of group")
 	}
-	return nil
+
+	if err := handOverGroupAdmin(tx, groupID); err != nil {
+		return err
+	}
+	if err := deleteUnmanagedWebhooks(tx, groupID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handOverGroupAdmin makes the longest-standing member of each group left without an
+// admin its admin. Bots never get the role. With a groupID, only that group is checked.
+func handOverGroupAdmin(ex interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, groupID string) error {
+	_, err := ex.Exec(`
+		UPDATE conversation_members SET admin = 1
+		WHERE rowid IN (
+			SELECT (
+				SELECT cm.rowid FROM conversation_members cm
+				INNER JOIN users u ON cm.user_id = u.id
+				WHERE cm.conversation_id = c.id AND u.bot = 0
+				ORDER BY cm.rowid
+				LIMIT 1
+			)
+			FROM conversations c
+			WHERE c.type = 'group' AND (? = '' OR c.id = ?) AND NOT EXISTS (
+				SELECT 1 FROM conversation_members a WHERE a.conversation_id = c.id AND a.admin = 1
+			)
+		)
+	`, groupID, groupID)
+	return err
+}
+
+// SetGroupAdmin grants or revokes the admin role of a group member. Revoking the role
+// of the last admin fails with ErrLastGroupAdmin, otherwise it deletes the webhooks the
+// member can no longer manage and their incoming webhooks.
+func (db *appdbimpl) SetGroupAdmin(groupID, userID string, admin bool) error {
+	if admin {
+		_, err := db.c.Exec("UPDATE conversation_members SET admin = 1 WHERE conversation_id = ? AND user_id = ?", groupID, userID)
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Checked in the same statement, so that two admins can't demote each other at once
+	result, err := tx.Exec(`
+		UPDATE conversation_members SET admin = 0
+		WHERE conversation_id = ? AND user_id = ? AND (
+			SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ? AND admin = 1 AND user_id != ?
+		) > 0
+	`, groupID, userID, groupID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrLastGroupAdmin
+	}
+
+	if err := deleteUnmanagedWebhooks(tx, groupID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetGroupAdminIDs lists the admins of a group
+func (db *appdbimpl) GetGroupAdminIDs(groupID string) ([]string, error) {
+	return queryStrings(db.c, "SELECT user_id FROM conversation_members WHERE conversation_id = ? AND admin = 1 ORDER BY rowid", groupID)
 }
 
 // UpdateGroupName updates the name of a group
@@ -215,7 +436,7 @@ func (db *appdbimpl) UpdateGroupPhoto(groupID string, photo []byte) error {
 // GetGroupMembers gets all members of a group
 func (db *appdbimpl) GetGroupMembers(groupID string) ([]User, error) {
 	rows, err := db.c.Query(`
-		SELECT u.id, u.username, u.photo 
+		SELECT u.id, u.username, u.photo, u.bot
 		FROM users u
 		INNER JOIN conversation_members cm ON u.id = cm.user_id
 		WHERE cm.conversation_id = ?
@@ -228,7 +449,7 @@ func (db *appdbimpl) GetGroupMembers(groupID string) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Photo); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Photo, &user.Bot); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
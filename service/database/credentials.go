@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetPassword retrieves a user's password credential, or nil if the user has no password
+func (db *appdbimpl) GetPassword(userID string) (*Password, error) {
+	var password Password
+	var lockedUntil sql.NullString
+	err := db.c.QueryRow(`
+		SELECT hash, failed_attempts, locked_until,
+			locked_until IS NOT NULL AND locked_until > CURRENT_TIMESTAMP
+		FROM passwords WHERE user_id = ?
+	`, userID).Scan(&password.Hash, &password.FailedAttempts, &lockedUntil, &password.Locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		password.LockedUntil = lockedUntil.String
+	}
+	return &password, nil
+}
+
+// SetPassword sets or replaces a user's password hash, clearing failed logins and lockouts
+func (db *appdbimpl) SetPassword(userID, hash string) error {
+	_, err := db.c.Exec(`
+		INSERT INTO passwords (user_id, hash, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			hash = excluded.hash,
+			failed_attempts = 0,
+			locked_until = NULL,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, hash)
+	return err
+}
+
+// RecordLoginFailure counts a failed login. Reaching maxAttempts locks the password
+// until lockUntil and starts counting again.
+func (db *appdbimpl) RecordLoginFailure(userID string, maxAttempts int, lockUntil string) error {
+	_, err := db.c.Exec(`
+		UPDATE passwords SET
+			locked_until = CASE WHEN failed_attempts + 1 >= ? THEN datetime(?) ELSE locked_until END,
+			failed_attempts = CASE WHEN failed_attempts + 1 >= ? THEN 0 ELSE failed_attempts + 1 END
+		WHERE user_id = ?
+	`, maxAttempts, lockUntil, maxAttempts, userID)
+	return err
+}
+
+// ResetLoginFailures clears the failed logins of a user after a successful one
+func (db *appdbimpl) ResetLoginFailures(userID string) error {
+	_, err := db.c.Exec("UPDATE passwords SET failed_attempts = 0 WHERE user_id = ?", userID)
+	return err
+}
+
+// CreateSession stores the hash of a new session token of a user
+func (db *appdbimpl) CreateSession(tokenHash, userID string) error {
+	_, err := db.c.Exec("INSERT INTO sessions (token_hash, user_id) VALUES (?, ?)", tokenHash, userID)
+	return err
+}
+
+// UseSession returns the user a session token hash belongs to and records that the
+// session was used, or returns "" if there is no such session or it expired. Sessions
+// expire when created before createdAfter or last used before usedAfter, and are
+// then deleted.
+func (db *appdbimpl) UseSession(tokenHash, createdAfter, usedAfter string) (string, error) {
+	var userID string
+	err := db.c.QueryRow(`
+		SELECT user_id FROM sessions
+		WHERE token_hash = ? AND created_at > datetime(?) AND COALESCE(last_used_at, created_at) > datetime(?)
+	`, tokenHash, createdAfter, usedAfter).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = db.c.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Recorded at most once a minute, not to write on every request
+	_, err = db.c.Exec(`
+		UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))
+	`, tokenHash)
+	return userID, err
+}
+
+// DeleteSession ends a session
+func (db *appdbimpl) DeleteSession(tokenHash string) error {
+	_, err := db.c.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteUserSessions ends every session of a user except the one with keepTokenHash
+func (db *appdbimpl) DeleteUserSessions(userID, keepTokenHash string) error {
+	_, err := db.c.Exec("DELETE FROM sessions WHERE user_id = ? AND token_hash != ?", userID, keepTokenHash)
+	return err
+}
@@ -19,16 +19,118 @@ type AppDatabase interface {
 	GetUserByUsername(username string) (*User, error)
 	UpdateUsername(userID, newUsername string) error
 	UpdateUserPhoto(userID string, photo []byte) error
-	SearchUsers(query string) ([]User, error)
+	SearchUsers(query, viewerID string) ([]User, error)
+	DeleteUser(userID string) error
+	GetUserMessageIDs(userID string) ([]string, error)
+	GetUserComments(userID string) ([]Comment, error)
+	GetPrivacySettings(userID string) (*PrivacySettings, error)
+	UpdatePrivacySettings(userID string, settings *PrivacySettings) error
+
+	// Administration operations
+	ListUsers(query string, limit, offset int) ([]User, error)
+	SetUserAdmin(userID string, admin bool) error
+	RevokePasswordlessAdmins() ([]User, error)
+	SuspendUser(userID string) error
+	UnsuspendUser(userID string) error
+	GetServerStats() (*ServerStats, error)
+
+	// Moderation operations
+	CreateReport(report *Report, media []ReportMedia) error
+	HasOpenReport(reporterID, messageID, reportedUserID string) (bool, error)
+	GetReport(id string) (*Report, error)
+	GetReports(status string, limit, offset int) ([]Report, error)
+	GetReportMedia(reportID string, position int) (*ReportMedia, error)
+	AddReportDecision(reportID string, decision ReportDecision, status string) error
+
+	// Audit log operations
+	AppendAuditEntry(entry *AuditEntry) error
+	GetLastAuditEntry() (*AuditEntry, error)
+	GetAuditEntries(filter AuditFilter, limit int) ([]AuditEntry, error)
+	GetAuditChain(afterSeq int64, limit int) ([]AuditEntry, error)
+
+	// Credential operations
+	GetPassword(userID string) (*Password, error)
+	SetPassword(userID, hash string) error
+	RecordLoginFailure(userID string, maxAttempts int, lockUntil string) error
+	ResetLoginFailures(userID string) error
+	CreateSession(tokenHash, userID string) error
+	UseSession(tokenHash, createdAfter, usedAfter string) (string, error)
+	DeleteSession(tokenHash string) error
+	DeleteUserSessions(userID, keepTokenHash string) error
+	GetTOTP(userID string) (*TOTP, error)
+	SetTOTPSecret(userID, secret string) error
+	EnableTOTP(userID string, recoveryCodeHashes []string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	UseRecoveryCode(userID, codeHash string) (bool, error)
+	DeleteTOTP(userID string) error
+	GetOIDCUserID(issuer, subject string) (string, error)
+	HasOIDCIdentity(userID string) (bool, error)
+	CreateBot(id, username, ownerID string) error
+	GetBots(ownerID string) ([]User, error)
+	CreateAPIKey(key *APIKey, keyHash string) error
+	GetAPIKeys(botID string) ([]APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+	MarkAPIKeyUsed(id string) error
+	RevokeAPIKey(botID, keyID string) error
+
+	// Webhook operations
+	IsGroupAdmin(groupID, userID string) (bool, error)
+	IsWebhookManager(conversationID, userID string) (bool, error)
+	CreateWebhook(webhook *Webhook) error
+	GetWebhook(id string) (*Webhook, error)
+	GetWebhooks(conversationID string) ([]Webhook, error)
+	DeleteWebhook(id string) error
+	CreateWebhookDeliveries(deliveries []WebhookDelivery) error
+	GetWebhookDelivery(id string) (*WebhookDelivery, error)
+	GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+	GetDueWebhookDeliveries(limit int) ([]WebhookDelivery, error)
+	DeleteWebhookDeliveries(before string) error
+	RecordWebhookAttempt(id string, statusCode int, attemptErr string, status, nextAttemptAt string) error
+
+	// Bot command operations
+	SetBotEndpoint(botID, url, secret string) error
+	GetBotEndpoint(botID string) (*BotEndpoint, error)
+	DeleteBotEndpoint(botID string) error
+	SetBotCommands(botID string, commands []BotCommand) error
+	GetBotCommands(botID string) ([]BotCommand, error)
+	GetConversationCommands(conversationID string) ([]BotCommand, error)
+	UpdateBotMessage(id, content string, buttons [][]MessageButton) error
+
+	// Incoming webhook operations
+	CreateIncomingWebhook(hook *IncomingWebhook, tokenHash string) error
+	GetIncomingWebhook(id string) (*IncomingWebhook, error)
+	GetIncomingWebhooks(groupID string) ([]IncomingWebhook, error)
+	GetIncomingWebhookByTokenHash(tokenHash string) (*IncomingWebhook, error)
+	UpdateIncomingWebhook(id, name string, enabled bool) error
+	RotateIncomingWebhookToken(id, prefix, tokenHash string) error
+	MarkIncomingWebhookUsed(id string) error
+	DeleteIncomingWebhook(id string) error
+	CreateOIDCUser(userID, username, issuer, subject string) error
+
+	// Block operations
+	BlockUser(blockerID, blockedID string) error
+	UnblockUser(blockerID, blockedID string) error
+	IsBlocked(blockerID, blockedID string) (bool, error)
+	IsBlockedInPrivateConversation(conversationID, userID string) (bool, error)
+	GetBlockedUsers(blockerID string) ([]User, error)
 
 	// Conversation operations
-	CreatePrivateConversation(id, user1ID, user2ID string) error
+	CreatePrivateConversation(id, user1ID, user2ID string, asRequest bool) error
 	CreateGroupConversation(id, name, creatorID string) error
 	GetConversation(id string) (*Conversation, error)
 	GetUserConversations(userID string) ([]ConversationPreview, error)
+	DeleteConversation(id string) error
 	GetPrivateConversation(user1ID, user2ID string) (*Conversation, error)
 	IsConversationMember(conversationID, userID string) (bool, error)
 	MarkConversationRead(conversationID, userID string) error
+	SharesConversation(user1ID, user2ID string) (bool, error)
+
+	// Message request operations
+	GetMessageRequests(userID string) ([]ConversationPreview, error)
+	IsMessageRequest(conversationID, userID string) (bool, error)
+	AcceptMessageRequest(conversationID, userID string) error
+	DeclineMessageRequest(conversationID, userID string) error
+	DeclinedRequestSince(userID, senderID, since string) (bool, error)
 
 	// Group operations
 	AddGroupMember(groupID, userID string) error
@@ -36,14 +138,35 @@ type AppDatabase interface {
 	UpdateGroupName(groupID, name string) error
 	UpdateGroupPhoto(groupID string, photo []byte) error
 	GetGroupMembers(groupID string) ([]User, error)
+	SetGroupAdmin(groupID, userID string, admin bool) error
+	GetGroupAdminIDs(groupID string) ([]string, error)
 
 	// Message operations
 	CreateMessage(msg *Message) error
+	CreateMessages(msgs []*Message) error
 	GetMessage(id string) (*Message, error)
+	GetMessageType(id string) (conversationID, msgType string, err error)
+	GetMessageMediaItem(messageID string, position int) (*MediaItem, error)
+	SetMessageThumbnail(messageID string, thumbnail []byte) error
+	SetMessageMediaThumbnail(messageID string, position int, thumbnail []byte) error
 	DeleteMessage(id string) error
 	GetConversationMessages(conversationID string) ([]Message, error)
+	GetConversationMedia(conversationID string, types []string, beforeID string, limit int) ([]Message, error)
 	GetMessageCheckmarks(messageID string) (int, error)
 
+	// Poll operations
+	SetPollVote(messageID, userID string, positions []int) error
+	RemovePollVote(messageID, userID string) error
+	ClosePoll(messageID string) error
+
+	// Location operations
+	UpdateLiveLocation(messageID string, latitude, longitude, accuracy float64) error
+	StopLiveLocation(messageID string) error
+
+	// Storage operations
+	GetUserStorage(userID string) (*StorageUsage, error)
+	GetConversationStorage(conversationID string) (int64, error)
+
 	// Comment operations
 	AddComment(messageID, userID, comment string) error
 	RemoveComment(messageID, userID string) error
@@ -52,11 +175,222 @@ type AppDatabase interface {
 	Ping() error
 }
 
+// ErrLastGroupAdmin is returned when revoking the role of the only admin of a group
+var ErrLastGroupAdmin = errors.New("last group admin")
+
+// ErrVoteNotFound is returned when withdrawing a vote from a poll the user didn't vote in
+var ErrVoteNotFound = errors.New("vote not found")
+
+// ErrLiveLocationEnded is returned when moving a location that is not, or no longer, shared live
+var ErrLiveLocationEnded = errors.New("live location ended")
+
+// ErrUsernameTaken is returned when creating a user with a username already in use
+var ErrUsernameTaken = errors.New("username taken")
+
+// ErrOIDCIdentityExists is returned when creating a user for an identity provider
+// subject that already logs in as another user
+var ErrOIDCIdentityExists = errors.New("identity provider subject already linked")
+
 // User represents a user in the database
 type User struct {
 	ID       string
 	Username string
 	Photo    []byte
+	Bot      bool
+	OwnerID  string // user who created the bot, empty for people
+
+	Admin       bool   // server administrator
+	SuspendedAt string // when an admin suspended the account, empty if active
+}
+
+// ServerStats sums up the content of the server
+type ServerStats struct {
+	Users          int64
+	Bots           int64
+	Admins         int64
+	SuspendedUsers int64
+	Groups         int64
+	PrivateChats   int64
+	Messages       int64
+	MediaBytes     int64 // profile and group photos and message media
+	DatabaseBytes  int64
+}
+
+// API key scopes
+const (
+	ScopeConversationsRead = "conversations:read"
+	ScopeMessagesSend      = "messages:send"
+)
+
+// APIKey represents a key a bot authenticates with
+type APIKey struct {
+	ID              string
+	BotID           string
+	Name            string
+	Prefix          string   // first characters of the key, to tell keys apart
+	Scopes          []string // what the key may do
+	ConversationIDs []string // conversations the key is restricted to, empty for any
+	CreatedAt       string
+	LastUsedAt      string
+}
+
+// Audiences a privacy setting can be restricted to. Contacts are the users who share
+// an accepted conversation with the owner of the setting.
+const (
+	AudienceEveryone = "everyone"
+	AudienceContacts = "contacts"
+	AudienceNobody   = "nobody"
+)
+
+// PrivacySettings represents what a user shares with others
+type PrivacySettings struct {
+	ForwardAttribution string // audience of the attribution of forwards of the user's messages, everyone or nobody
+	PhotoVisibility    string // audience of the profile photo
+	GroupAdd           string // audience allowed to add the user to groups
+	ReadReceipts       bool   // whether reading a conversation marks its messages as read
+	Searchable         bool   // whether the user appears in searches
+}
+
+// Password represents the password credential of a user
+type Password struct {
+	Hash           string
+	FailedAttempts int    // failed logins since the last success or lockout
+	LockedUntil    string // end of the current or last lockout, empty if never locked
+	Locked         bool   // whether logins are refused until LockedUntil
+}
+
+// TOTP represents the two-factor authentication secret of a user
+type TOTP struct {
+	Secret        string
+	Enabled       bool // false while the enrolment is not confirmed with a code
+	RecoveryCodes int  // unused recovery codes
+}
+
+// Webhook represents a URL that receives the events of a conversation
+type Webhook struct {
+	ID             string
+	ConversationID string
+	CreatedBy      string
+	URL            string
+	Secret         string   // key of the HMAC-SHA256 signature of deliveries
+	Events         []string // events delivered, empty for every event
+	CreatedAt      string
+}
+
+// MessageButton represents an inline button of a message sent by a bot
+type MessageButton struct {
+	Text string
+	Data string // sent back to the bot when the button is pressed
+}
+
+// BotEndpoint is the URL a bot receives its commands and button presses at
+type BotEndpoint struct {
+	BotID  string
+	URL    string
+	Secret string // key of the HMAC-SHA256 signature of requests
+}
+
+// BotCommand represents a slash command a bot answers
+type BotCommand struct {
+	BotID       string
+	BotUsername string
+	Command     string // without the slash
+	Description string
+}
+
+// IncomingWebhook represents a secret URL that posts messages into a group
+type IncomingWebhook struct {
+	ID             string
+	ConversationID string
+	CreatedBy      string // messages are sent on behalf of this group admin
+	Name           string // shown as the author of the messages
+	Prefix         string // first characters of the token, to tell hooks apart
+	Enabled        bool
+	CreatedAt      string
+	LastUsedAt     string
+}
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // gave up after the last retry
+)
+
+// WebhookDelivery represents one event queued for or sent to a webhook
+type WebhookDelivery struct {
+	ID             string
+	WebhookID      string
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  string
+	LastStatusCode int    // HTTP status of the last attempt, 0 if it got no response
+	LastError      string // why the last attempt failed
+	CreatedAt      string
+	DeliveredAt    string
+}
+
+// Statuses of a report
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned" // the message was deleted or the user suspended
+)
+
+// Report represents the report of a message or a user to the server admins
+type Report struct {
+	ID             string
+	ReporterID     string
+	MessageID      string // empty when a user is reported
+	ConversationID string // conversation of the reported message
+	ReportedUserID string // the reported user, or the sender of the reported message
+	Reason         string
+	Details        string
+	Snapshot       string // JSON copy of the reported content, kept after it is deleted
+	MediaCount     int    // media stored with the snapshot
+	Status         string
+	CreatedAt      string
+	Decisions      []ReportDecision // oldest first
+}
+
+// ReportMedia is a photo or file copied into the snapshot of a report
+type ReportMedia struct {
+	MimeType string
+	Data     []byte
+}
+
+// ReportDecision records an admin acting on a report
+type ReportDecision struct {
+	AdminID   string
+	Action    string
+	Note      string
+	CreatedAt string
+}
+
+// AuditEntry is a security-relevant action in the append-only audit log. Each entry
+// holds the hash of the previous one, so changing or removing an entry breaks the chain.
+type AuditEntry struct {
+	Seq       int64
+	CreatedAt string // RFC 3339 with nanoseconds, set by the writer as it is hashed
+	ActorID   string // user who acted, empty when unauthenticated or for the server itself
+	Action    string
+	TargetID  string // user, group, message or other record acted on
+	IP        string
+	Details   string // JSON object
+	PrevHash  string
+	Hash      string
+}
+
+// AuditFilter selects audit log entries, empty fields match any entry
+type AuditFilter struct {
+	ActorID   string
+	Action    string
+	TargetID  string
+	Since     string // same format as CreatedAt, inclusive
+	Until     string // same format as CreatedAt, exclusive
+	BeforeSeq int64  // entries older than this one, 0 for the newest
 }
 
 // Conversation represents a conversation
@@ -79,6 +413,10 @@ type ConversationPreview struct {
 // MessagePreview represents a message preview
 type MessagePreview struct {
 	Content   string
+	Type      string
+	Caption   string
+	AltText   string
+	FileName  string
 	Timestamp string
 	SenderID  string
 }
@@ -90,12 +428,87 @@ type Message struct {
 	SenderID       string
 	Content        string
 	Photo          []byte
-	Type           string // "text" or "photo"
+	Thumbnail      []byte // cached scaled down photo, only loaded by GetMessage
+	Caption        string
+	AltText        string
+	FileData       []byte // not loaded by GetConversationMessages
+	FileName       string
+	MimeType       string
+	FileSize       int64
+	DurationMs     int64
+	Media          []MediaItem // album items, in display order
+	Poll           *Poll
+	Location       *Location
+	ContactUserID  string            // user shared by a contact card
+	Integration    string            // name of the incoming webhook that posted the message
+	Buttons        [][]MessageButton // inline buttons of a bot message, in rows
+	Type           string            // "text", "photo", "file", "audio", "album", "poll", "location" or "contact"
 	ReplyToID      string
 	Forwarded      bool
+	ForwardedFrom  *ForwardOrigin // set on forwarded messages
+	ForwardCount   int            // times the original message was forwarded
 	CreatedAt      string
 }
 
+// ForwardOrigin identifies the original message a forwarded message was copied from
+type ForwardOrigin struct {
+	MessageID      string
+	UserID         string
+	ConversationID string
+}
+
+// MediaItem represents one photo of an album message
+type MediaItem struct {
+	Position  int
+	Photo     []byte // not loaded by GetConversationMessages
+	Thumbnail []byte // cached scaled down photo, only loaded by GetMessageMediaItem
+	Caption   string
+	AltText   string
+}
+
+// Poll represents the poll of a poll message
+type Poll struct {
+	Question       string
+	Options        []PollOption
+	MultipleChoice bool
+	Anonymous      bool
+	ClosesAt       string // RFC 3339, empty if the poll stays open until closed by the author
+	Closed         bool   // closed by the author or past ClosesAt
+	TotalVoters    int
+}
+
+// PollOption represents one answer of a poll and who chose it
+type PollOption struct {
+	Position int
+	Text     string
+	VoterIDs []string
+}
+
+// Location represents the shared point of a location message
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Accuracy  float64 // radius in meters
+	Label     string
+	LiveUntil string // RFC 3339, empty for a static location
+	Live      bool   // still accepting updates
+	UpdatedAt string
+}
+
+// StorageUsage is the number of bytes of media uploaded by a user
+type StorageUsage struct {
+	Total          int64
+	ProfilePhoto   int64
+	ByType         map[string]int64
+	ByConversation []ConversationStorage
+}
+
+// ConversationStorage is the number of bytes a user uploaded to one conversation
+type ConversationStorage struct {
+	ConversationID string
+	Bytes          int64
+}
+
 // Comment represents a reaction/comment on a message
 type Comment struct {
 	MessageID string
@@ -130,7 +543,11 @@ func createTables(db *sql.DB) error {
 		`CREATE TABLE IF NOT EXISTS users (
 			id TEXT PRIMARY KEY,
 			username TEXT UNIQUE NOT NULL,
-			photo BLOB
+			photo BLOB,
+			bot INTEGER NOT NULL DEFAULT 0,
+			owner_id TEXT,
+			admin INTEGER NOT NULL DEFAULT 0,
+			suspended_at DATETIME
 		)`,
 		`CREATE TABLE IF NOT EXISTS conversations (
 			id TEXT PRIMARY KEY,
@@ -142,6 +559,8 @@ func createTables(db *sql.DB) error {
 			conversation_id TEXT NOT NULL,
 			user_id TEXT NOT NULL,
 			last_read_at DATETIME,
+			pending INTEGER NOT NULL DEFAULT 0,
+			admin INTEGER NOT NULL DEFAULT 0,
 			PRIMARY KEY (conversation_id, user_id),
 			FOREIGN KEY (conversation_id) REFERENCES conversations(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
@@ -152,13 +571,262 @@ func createTables(db *sql.DB) error {
 			sender_id TEXT NOT NULL,
 			content TEXT,
 			photo BLOB,
+			thumbnail BLOB,
+			caption TEXT,
+			alt_text TEXT,
+			file_data BLOB,
+			file_name TEXT,
+			mime_type TEXT,
+			file_size INTEGER,
+			duration_ms INTEGER,
+			contact_user_id TEXT,
 			type TEXT NOT NULL,
 			reply_to_id TEXT,
 			forwarded INTEGER DEFAULT 0,
+			forwarded_from_message_id TEXT,
+			forwarded_from_user_id TEXT,
+			forwarded_from_conversation_id TEXT,
+			integration TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (conversation_id) REFERENCES conversations(id),
 			FOREIGN KEY (sender_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS message_media (
+			message_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			photo BLOB NOT NULL,
+			thumbnail BLOB,
+			caption TEXT,
+			alt_text TEXT,
+			PRIMARY KEY (message_id, position),
+			FOREIGN KEY (message_id) REFERENCES messages(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS polls (
+			message_id TEXT PRIMARY KEY,
+			question TEXT NOT NULL,
+			multiple_choice INTEGER NOT NULL DEFAULT 0,
+			anonymous INTEGER NOT NULL DEFAULT 0,
+			closes_at DATETIME,
+			closed_at DATETIME,
+			FOREIGN KEY (message_id) REFERENCES messages(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_options (
+			message_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			PRIMARY KEY (message_id, position),
+			FOREIGN KEY (message_id) REFERENCES polls(message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			message_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			voted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id, position),
+			FOREIGN KEY (message_id) REFERENCES polls(message_id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS locations (
+			message_id TEXT PRIMARY KEY,
+			latitude REAL NOT NULL,
+			longitude REAL NOT NULL,
+			accuracy REAL NOT NULL DEFAULT 0,
+			label TEXT,
+			live_until DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (message_id) REFERENCES messages(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS blocks (
+			blocker_id TEXT NOT NULL,
+			blocked_id TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id),
+			FOREIGN KEY (blocker_id) REFERENCES users(id),
+			FOREIGN KEY (blocked_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS declined_requests (
+			user_id TEXT NOT NULL,
+			sender_id TEXT NOT NULL,
+			declined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, sender_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (sender_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS privacy_settings (
+			user_id TEXT PRIMARY KEY,
+			forward_attribution TEXT NOT NULL DEFAULT 'everyone',
+			photo_visibility TEXT NOT NULL DEFAULT 'everyone',
+			group_add TEXT NOT NULL DEFAULT 'everyone',
+			read_receipts INTEGER NOT NULL DEFAULT 1,
+			searchable INTEGER NOT NULL DEFAULT 1,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS passwords (
+			user_id TEXT PRIMARY KEY,
+			hash TEXT NOT NULL,
+			failed_attempts INTEGER NOT NULL DEFAULT 0,
+			locked_until DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS totp (
+			user_id TEXT PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 0,
+			last_step INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			user_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS oidc_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (issuer, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			bot_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY (bot_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_key_conversations (
+			key_id TEXT NOT NULL,
+			conversation_id TEXT NOT NULL,
+			PRIMARY KEY (key_id, conversation_id),
+			FOREIGN KEY (key_id) REFERENCES api_keys(id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			conversation_id TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_status_code INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_buttons (
+			message_id TEXT NOT NULL,
+			row INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (message_id, row, position),
+			FOREIGN KEY (message_id) REFERENCES messages(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS bot_endpoints (
+			bot_id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (bot_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS bot_commands (
+			bot_id TEXT NOT NULL,
+			command TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (bot_id, command),
+			FOREIGN KEY (bot_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS incoming_webhooks (
+			id TEXT PRIMARY KEY,
+			conversation_id TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS reports (
+			id TEXT PRIMARY KEY,
+			reporter_id TEXT NOT NULL,
+			message_id TEXT,
+			conversation_id TEXT,
+			reported_user_id TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			snapshot TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS report_media (
+			report_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			mime_type TEXT NOT NULL,
+			data BLOB NOT NULL,
+			PRIMARY KEY (report_id, position),
+			FOREIGN KEY (report_id) REFERENCES reports(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS report_decisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			report_id TEXT NOT NULL,
+			admin_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (report_id) REFERENCES reports(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			seq INTEGER PRIMARY KEY,
+			created_at TEXT NOT NULL,
+			actor_id TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target_id TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			details TEXT NOT NULL DEFAULT '{}',
+			prev_hash TEXT NOT NULL,
+			hash TEXT NOT NULL
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END`,
 		`CREATE TABLE IF NOT EXISTS message_comments (
 			message_id TEXT NOT NULL,
 			user_id TEXT NOT NULL,
@@ -176,9 +844,105 @@ func createTables(db *sql.DB) error {
 		}
 	}
 
+	if err := migrateTables(db); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_forwarded_from ON messages (forwarded_from_message_id)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at)")
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"actor_id", "action", "target_id"} {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_" + column + " ON audit_log (" + column + ", seq)")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTables adds columns introduced after the initial schema to databases
+// created by older versions
+func migrateTables(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{"messages", "caption", "TEXT"},
+		{"messages", "alt_text", "TEXT"},
+		{"messages", "file_data", "BLOB"},
+		{"messages", "file_name", "TEXT"},
+		{"messages", "mime_type", "TEXT"},
+		{"messages", "file_size", "INTEGER"},
+		{"messages", "duration_ms", "INTEGER"},
+		{"messages", "forwarded_from_message_id", "TEXT"},
+		{"messages", "forwarded_from_user_id", "TEXT"},
+		{"messages", "forwarded_from_conversation_id", "TEXT"},
+		{"messages", "contact_user_id", "TEXT"},
+		{"conversation_members", "pending", "INTEGER NOT NULL DEFAULT 0"},
+		{"privacy_settings", "photo_visibility", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"privacy_settings", "group_add", "TEXT NOT NULL DEFAULT 'everyone'"},
+		{"privacy_settings", "read_receipts", "INTEGER NOT NULL DEFAULT 1"},
+		{"privacy_settings", "searchable", "INTEGER NOT NULL DEFAULT 1"},
+		{"users", "bot", "INTEGER NOT NULL DEFAULT 0"},
+		{"conversation_members", "admin", "INTEGER NOT NULL DEFAULT 0"},
+		{"messages", "integration", "TEXT"},
+		{"users", "owner_id", "TEXT"},
+		{"users", "admin", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "suspended_at", "DATETIME"},
+		{"messages", "thumbnail", "BLOB"},
+		{"message_media", "thumbnail", "BLOB"},
+		{"sessions", "last_used_at", "DATETIME"},
+	}
+
+	for _, col := range columns {
+		if err := addColumnIfMissing(db, col.table, col.name, col.definition); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+
+	// Groups created before group admins existed get one
+	if err := handOverGroupAdmin(db, ""); err != nil {
+		return fmt.Errorf("assigning group admins: %w", err)
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to a table unless it already exists
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 func (db *appdbimpl) Ping() error {
 	return db.c.Ping()
 }
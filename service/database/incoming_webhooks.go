@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// CreateIncomingWebhook stores a new incoming webhook by the hash of its token
+func (db *appdbimpl) CreateIncomingWebhook(hook *IncomingWebhook, tokenHash string) error {
+	_, err := db.c.Exec(`
+		INSERT INTO incoming_webhooks (id, conversation_id, created_by, name, token_hash, prefix)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, hook.ID, hook.ConversationID, hook.CreatedBy, hook.Name, tokenHash, hook.Prefix)
+	return err
+}
+
+// GetIncomingWebhook retrieves an incoming webhook by ID
+func (db *appdbimpl) GetIncomingWebhook(id string) (*IncomingWebhook, error) {
+	hook, err := scanIncomingWebhook(db.c.QueryRow(`
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// GetIncomingWebhooks retrieves the incoming webhooks of a group, oldest first
+func (db *appdbimpl) GetIncomingWebhooks(groupID string) ([]IncomingWebhook, error) {
+	rows, err := db.c.Query(`
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhooks
+		WHERE conversation_id = ?
+		ORDER BY created_at, rowid
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []IncomingWebhook
+	for rows.Next() {
+		hook, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// GetIncomingWebhookByTokenHash retrieves the incoming webhook a token belongs to
+func (db *appdbimpl) GetIncomingWebhookByTokenHash(tokenHash string) (*IncomingWebhook, error) {
+	hook, err := scanIncomingWebhook(db.c.QueryRow(`
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE token_hash = ?
+	`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// UpdateIncomingWebhook renames an incoming webhook and enables or disables it
+func (db *appdbimpl) UpdateIncomingWebhook(id, name string, enabled bool) error {
+	_, err := db.c.Exec("UPDATE incoming_webhooks SET name = ?, enabled = ? WHERE id = ?", name, enabled, id)
+	return err
+}
+
+// RotateIncomingWebhookToken replaces the token of an incoming webhook, the old one stops working
+func (db *appdbimpl) RotateIncomingWebhookToken(id, prefix, tokenHash string) error {
+	_, err := db.c.Exec("UPDATE incoming_webhooks SET prefix = ?, token_hash = ? WHERE id = ?", prefix, tokenHash, id)
+	return err
+}
+
+// MarkIncomingWebhookUsed records that an incoming webhook just posted a message
+func (db *appdbimpl) MarkIncomingWebhookUsed(id string) error {
+	_, err := db.c.Exec("UPDATE incoming_webhooks SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// DeleteIncomingWebhook removes an incoming webhook. Its messages are kept.
+func (db *appdbimpl) DeleteIncomingWebhook(id string) error {
+	_, err := db.c.Exec("DELETE FROM incoming_webhooks WHERE id = ?", id)
+	return err
+}
+
+const incomingWebhookColumns = "id, conversation_id, created_by, name, prefix, enabled, created_at, last_used_at"
+
+func scanIncomingWebhook(row interface{ Scan(...interface{}) error }) (*IncomingWebhook, error) {
+	var hook IncomingWebhook
+	var lastUsedAt sql.NullString
+	err := row.Scan(&hook.ID, &hook.ConversationID, &hook.CreatedBy, &hook.Name, &hook.Prefix, &hook.Enabled,
+		&hook.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		hook.LastUsedAt = lastUsedAt.String
+	}
+	return &hook, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// createLocation stores the point of a location message within the message's transaction
+func createLocation(tx *sql.Tx, messageID string, loc *Location) error {
+	var liveUntil interface{}
+	if loc.LiveUntil != "" {
+		liveUntil = loc.LiveUntil
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO locations (message_id, latitude, longitude, accuracy, label, live_until, updated_at)
+		VALUES (?, ?, ?, ?, ?, datetime(?), CURRENT_TIMESTAMP)
+	`, messageID, loc.Latitude, loc.Longitude, loc.Accuracy, loc.Label, liveUntil)
+	return err
+}
+
+// getLocation loads the latest point of a location message, or nil if the message has none
+func (db *appdbimpl) getLocation(messageID string) (*Location, error) {
+	var loc Location
+	var liveUntil sql.NullString
+	err := db.c.QueryRow(`
+		SELECT latitude, longitude, accuracy, COALESCE(label, ''), live_until,
+			live_until IS NOT NULL AND live_until > CURRENT_TIMESTAMP, updated_at
+		FROM locations WHERE message_id = ?
+	`, messageID).Scan(&loc.Latitude, &loc.Longitude, &loc.Accuracy, &loc.Label, &liveUntil, &loc.Live, &loc.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if liveUntil.Valid {
+		loc.LiveUntil = liveUntil.String
+	}
+	return &loc, nil
+}
+
+// UpdateLiveLocation moves the point of a live location that has not ended yet
+func (db *appdbimpl) UpdateLiveLocation(messageID string, latitude, longitude, accuracy float64) error {
+	result, err := db.c.Exec(`
+		UPDATE locations SET latitude = ?, longitude = ?, accuracy = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE message_id = ? AND live_until IS NOT NULL AND live_until > CURRENT_TIMESTAMP
+	`, latitude, longitude, accuracy, messageID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrLiveLocationEnded
+	}
+	return nil
+}
+
+// StopLiveLocation ends a live location, keeping its last point
+func (db *appdbimpl) StopLiveLocation(messageID string) error {
+	_, err := db.c.Exec(`
+		UPDATE locations SET live_until = CURRENT_TIMESTAMP
+		WHERE message_id = ? AND live_until > CURRENT_TIMESTAMP
+	`, messageID)
+	return err
+}
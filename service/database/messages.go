@@ -3,10 +3,37 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 )
 
+// forwardCountColumn counts the forwards of the original of the selected message
+const forwardCountColumn = `(SELECT COUNT(*) FROM messages f
+	WHERE f.forwarded_from_message_id = COALESCE(messages.forwarded_from_message_id, messages.id))`
+
 // CreateMessage creates a new message
 func (db *appdbimpl) CreateMessage(msg *Message) error {
+	return db.CreateMessages([]*Message{msg})
+}
+
+// CreateMessages creates several messages atomically: either all of them are stored or none
+func (db *appdbimpl) CreateMessages(msgs []*Message) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, msg := range msgs {
+		if err := insertMessage(tx, msg); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertMessage stores a message with its album items, poll and location
+func insertMessage(tx *sql.Tx, msg *Message) error {
 	forwarded := 0
 	if msg.Forwarded {
 		forwarded = 1
@@ -17,11 +44,58 @@ func (db *appdbimpl) CreateMessage(msg *Message) error {
 		replyToID = msg.ReplyToID
 	}
 
-	_, err := db.c.Exec(`
-		INSERT INTO messages (id, conversation_id, sender_id, content, photo, type, reply_to_id, forwarded, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-	`, msg.ID, msg.ConversationID, msg.SenderID, msg.Content, msg.Photo, msg.Type, replyToID, forwarded)
-	return err
+	var contactUserID interface{}
+	if msg.ContactUserID != "" {
+		contactUserID = msg.ContactUserID
+	}
+
+	var integration interface{}
+	if msg.Integration != "" {
+		integration = msg.Integration
+	}
+
+	var fromMessageID, fromUserID, fromConversationID interface{}
+	if msg.ForwardedFrom != nil {
+		fromMessageID = msg.ForwardedFrom.MessageID
+		fromUserID = msg.ForwardedFrom.UserID
+		fromConversationID = msg.ForwardedFrom.ConversationID
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO messages (id, conversation_id, sender_id, content, photo, caption, alt_text,
+			file_data, file_name, mime_type, file_size, duration_ms, contact_user_id, type, reply_to_id, forwarded,
+			forwarded_from_message_id, forwarded_from_user_id, forwarded_from_conversation_id, integration, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, msg.ID, msg.ConversationID, msg.SenderID, msg.Content, msg.Photo, msg.Caption, msg.AltText,
+		msg.FileData, msg.FileName, msg.MimeType, msg.FileSize, msg.DurationMs, contactUserID, msg.Type, replyToID, forwarded,
+		fromMessageID, fromUserID, fromConversationID, integration)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range msg.Media {
+		_, err = tx.Exec(`
+			INSERT INTO message_media (message_id, position, photo, caption, alt_text)
+			VALUES (?, ?, ?, ?, ?)
+		`, msg.ID, i, item.Photo, item.Caption, item.AltText)
+		if err != nil {
+			return err
+		}
+	}
+
+	if msg.Poll != nil {
+		if err := createPoll(tx, msg.ID, msg.Poll); err != nil {
+			return err
+		}
+	}
+
+	if msg.Location != nil {
+		if err := createLocation(tx, msg.ID, msg.Location); err != nil {
+			return err
+		}
+	}
+
+	return insertButtons(tx, msg.ID, msg.Buttons)
 }
 
 // GetMessage retrieves a message by ID
@@ -29,11 +103,18 @@ func (db *appdbimpl) GetMessage(id string) (*Message, error) {
 	var msg Message
 	var replyToID sql.NullString
 	var forwarded int
+	var origin forwardOriginColumns
 
 	err := db.c.QueryRow(`
-		SELECT id, conversation_id, sender_id, content, photo, type, reply_to_id, forwarded, created_at
+		SELECT id, conversation_id, sender_id, content, photo, thumbnail, COALESCE(caption, ''), COALESCE(alt_text, ''),
+			file_data, COALESCE(file_name, ''), COALESCE(mime_type, ''), COALESCE(file_size, 0), COALESCE(duration_ms, 0),
+			COALESCE(contact_user_id, ''), type, reply_to_id, forwarded, forwarded_from_message_id, forwarded_from_user_id, forwarded_from_conversation_id,
+			COALESCE(integration, ''), `+forwardCountColumn+`, created_at
 		FROM messages WHERE id = ?
-	`, id).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.Photo, &msg.Type, &replyToID, &forwarded, &msg.CreatedAt)
+	`, id).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.Photo, &msg.Thumbnail, &msg.Caption, &msg.AltText,
+		&msg.FileData, &msg.FileName, &msg.MimeType, &msg.FileSize, &msg.DurationMs,
+		&msg.ContactUserID, &msg.Type, &replyToID, &forwarded, &origin.messageID, &origin.userID, &origin.conversationID,
+		&msg.Integration, &msg.ForwardCount, &msg.CreatedAt)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -46,19 +127,150 @@ func (db *appdbimpl) GetMessage(id string) (*Message, error) {
 		msg.ReplyToID = replyToID.String
 	}
 	msg.Forwarded = forwarded == 1
+	msg.ForwardedFrom = origin.toOrigin()
+
+	msg.Media, err = db.getMessageMedia(msg.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Buttons, err = db.getMessageButtons(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case "poll":
+		msg.Poll, err = db.getPoll(msg.ID)
+	case "location":
+		msg.Location, err = db.getLocation(msg.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	return &msg, nil
 }
 
+// GetMessageType returns the conversation and the type of a message without loading
+// its content, or empty strings if it does not exist
+func (db *appdbimpl) GetMessageType(id string) (conversationID, msgType string, err error) {
+	err = db.c.QueryRow("SELECT conversation_id, type FROM messages WHERE id = ?", id).Scan(&conversationID, &msgType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return conversationID, msgType, err
+}
+
+// GetMessageMediaItem retrieves a single album item with its photo and cached thumbnail
+func (db *appdbimpl) GetMessageMediaItem(messageID string, position int) (*MediaItem, error) {
+	var item MediaItem
+	err := db.c.QueryRow(`
+		SELECT position, photo, thumbnail, COALESCE(caption, ''), COALESCE(alt_text, '')
+		FROM message_media WHERE message_id = ? AND position = ?
+	`, messageID, position).Scan(&item.Position, &item.Photo, &item.Thumbnail, &item.Caption, &item.AltText)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// SetMessageThumbnail caches the thumbnail of a photo message
+func (db *appdbimpl) SetMessageThumbnail(messageID string, thumbnail []byte) error {
+	_, err := db.c.Exec("UPDATE messages SET thumbnail = ? WHERE id = ?", thumbnail, messageID)
+	return err
+}
+
+// SetMessageMediaThumbnail caches the thumbnail of an album item
+func (db *appdbimpl) SetMessageMediaThumbnail(messageID string, position int, thumbnail []byte) error {
+	_, err := db.c.Exec("UPDATE message_media SET thumbnail = ? WHERE message_id = ? AND position = ?", thumbnail, messageID, position)
+	return err
+}
+
+// forwardOriginColumns scans the nullable forward origin of a message
+type forwardOriginColumns struct {
+	messageID      sql.NullString
+	userID         sql.NullString
+	conversationID sql.NullString
+}
+
+// toOrigin returns the scanned origin, or nil for messages that were not forwarded with attribution
+func (c forwardOriginColumns) toOrigin() *ForwardOrigin {
+	if !c.messageID.Valid {
+		return nil
+	}
+	return &ForwardOrigin{
+		MessageID:      c.messageID.String,
+		UserID:         c.userID.String,
+		ConversationID: c.conversationID.String,
+	}
+}
+
+// getMessageMedia loads the album items of a message, optionally with their photos
+func (db *appdbimpl) getMessageMedia(messageID string, withPhotos bool) ([]MediaItem, error) {
+	photoColumn := "NULL"
+	if withPhotos {
+		photoColumn = "photo"
+	}
+	rows, err := db.c.Query(`
+		SELECT position, `+photoColumn+`, COALESCE(caption, ''), COALESCE(alt_text, '')
+		FROM message_media
+		WHERE message_id = ?
+		ORDER BY position
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []MediaItem
+	for rows.Next() {
+		var item MediaItem
+		if err := rows.Scan(&item.Position, &item.Photo, &item.Caption, &item.AltText); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
 // DeleteMessage deletes a message
 func (db *appdbimpl) DeleteMessage(id string) error {
-	// First delete comments on the message
-	_, err := db.c.Exec("DELETE FROM message_comments WHERE message_id = ?", id)
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// First delete comments and album items of the message
+	_, err = tx.Exec("DELETE FROM message_comments WHERE message_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM message_media WHERE message_id = ?", id)
 	if err != nil {
 		return err
 	}
 
-	result, err := db.c.Exec("DELETE FROM messages WHERE id = ?", id)
+	_, err = tx.Exec("DELETE FROM message_buttons WHERE message_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if err := deletePoll(tx, id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM locations WHERE message_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM messages WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
@@ -69,13 +281,17 @@ func (db *appdbimpl) DeleteMessage(id string) error {
 	if rowsAffected == 0 {
 		return errors.New("message not found")
 	}
-	return nil
+	return tx.Commit()
 }
 
-// GetConversationMessages retrieves all messages in a conversation (reverse chronological)
+// GetConversationMessages retrieves all messages in a conversation (reverse chronological).
+// File contents are left out, use GetMessage to load them.
 func (db *appdbimpl) GetConversationMessages(conversationID string) ([]Message, error) {
 	rows, err := db.c.Query(`
-		SELECT id, conversation_id, sender_id, content, photo, type, reply_to_id, forwarded, created_at
+		SELECT id, conversation_id, sender_id, content, photo, COALESCE(caption, ''), COALESCE(alt_text, ''),
+			COALESCE(file_name, ''), COALESCE(mime_type, ''), COALESCE(file_size, 0), COALESCE(duration_ms, 0),
+			COALESCE(contact_user_id, ''), type, reply_to_id, forwarded, forwarded_from_message_id, forwarded_from_user_id, forwarded_from_conversation_id,
+			COALESCE(integration, ''), `+forwardCountColumn+`, created_at
 		FROM messages 
 		WHERE conversation_id = ?
 		ORDER BY created_at DESC
@@ -90,8 +306,12 @@ func (db *appdbimpl) GetConversationMessages(conversationID string) ([]Message,
 		var msg Message
 		var replyToID sql.NullString
 		var forwarded int
+		var origin forwardOriginColumns
 
-		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.Photo, &msg.Type, &replyToID, &forwarded, &msg.CreatedAt); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.Photo, &msg.Caption, &msg.AltText,
+			&msg.FileName, &msg.MimeType, &msg.FileSize, &msg.DurationMs,
+			&msg.ContactUserID, &msg.Type, &replyToID, &forwarded, &origin.messageID, &origin.userID, &origin.conversationID,
+			&msg.Integration, &msg.ForwardCount, &msg.CreatedAt); err != nil {
 			return nil, err
 		}
 
@@ -99,10 +319,34 @@ func (db *appdbimpl) GetConversationMessages(conversationID string) ([]Message,
 			msg.ReplyToID = replyToID.String
 		}
 		msg.Forwarded = forwarded == 1
+		msg.ForwardedFrom = origin.toOrigin()
 
 		messages = append(messages, msg)
 	}
-	return messages, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	buttons, err := db.getConversationButtons(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		messages[i].Buttons = buttons[messages[i].ID]
+		switch messages[i].Type {
+		case "album":
+			messages[i].Media, err = db.getMessageMedia(messages[i].ID, false)
+		case "poll":
+			messages[i].Poll, err = db.getPoll(messages[i].ID)
+		case "location":
+			messages[i].Location, err = db.getLocation(messages[i].ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
 }
 
 // GetMessageCheckmarks calculates checkmarks for a message
@@ -132,7 +376,7 @@ func (db *appdbimpl) GetMessageCheckmarks(messageID string) (int, error) {
 	var readCount int
 	err = db.c.QueryRow(`
 		SELECT COUNT(*) FROM conversation_members 
-		WHERE conversation_id = ? AND user_id != ? AND last_read_at >= ?
+		WHERE conversation_id = ? AND user_id != ? AND last_read_at >= datetime(?)
 	`, conversationID, senderID, createdAt).Scan(&readCount)
 	if err != nil {
 		return 0, err
@@ -192,3 +436,64 @@ func (db *appdbimpl) GetMessageComments(messageID string) ([]Comment, error) {
 	}
 	return comments, rows.Err()
 }
+
+// GetConversationMedia retrieves up to limit messages of the given types in a conversation,
+// newest first and without their file contents. When beforeID is set, only messages older
+// than that message are returned.
+func (db *appdbimpl) GetConversationMedia(conversationID string, types []string, beforeID string, limit int) ([]Message, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT id, conversation_id, sender_id, COALESCE(caption, ''), COALESCE(alt_text, ''),
+			COALESCE(file_name, ''), COALESCE(mime_type, ''), COALESCE(file_size, 0), COALESCE(duration_ms, 0),
+			type, forwarded, created_at
+		FROM messages
+		WHERE conversation_id = ? AND type IN (?` + strings.Repeat(", ?", len(types)-1) + `)`
+	args := []interface{}{conversationID}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	if beforeID != "" {
+		query += " AND (created_at, rowid) < (SELECT created_at, rowid FROM messages WHERE id = ?)"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY created_at DESC, rowid DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		var forwarded int
+
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Caption, &msg.AltText,
+			&msg.FileName, &msg.MimeType, &msg.FileSize, &msg.DurationMs,
+			&msg.Type, &forwarded, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		msg.Forwarded = forwarded == 1
+
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		if messages[i].Type != "album" {
+			continue
+		}
+		messages[i].Media, err = db.getMessageMedia(messages[i].ID, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import "testing"
+
+func TestDeleteMessage(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.CreateUser("user", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateGroupConversation("group", "Group", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateMessage(&Message{ID: "message", ConversationID: "group", SenderID: "user", Type: "text", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddComment("message", "user", "👍"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteMessage("message"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := db.GetMessage("message"); err != nil || msg != nil {
+		t.Errorf("GetMessage = %v, %v, want no message", msg, err)
+	}
+	if comments, err := db.GetMessageComments("message"); err != nil || len(comments) != 0 {
+		t.Errorf("GetMessageComments = %v, %v, want no comments", comments, err)
+	}
+}
+
+func TestFailedDeleteMessageRollsBack(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.CreateUser("user", "user"); err != nil {
+		t.Fatal(err)
+	}
+	// A comment left without its message: deleting the message fails after the
+	// comments are deleted, and must not keep that deletion
+	if err := db.AddComment("missing", "user", "👍"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteMessage("missing"); err == nil {
+		t.Fatal("DeleteMessage of a missing message succeeded")
+	}
+	if comments, err := db.GetMessageComments("missing"); err != nil || len(comments) != 1 {
+		t.Errorf("GetMessageComments = %v, %v, want the comment kept", comments, err)
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetOIDCUserID returns the user an identity provider subject logs in as, or "" if it has none yet
+func (db *appdbimpl) GetOIDCUserID(issuer, subject string) (string, error) {
+	var userID string
+	err := db.c.QueryRow("SELECT user_id FROM oidc_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return userID, err
+}
+
+// HasOIDCIdentity reports whether a user logs in through an identity provider
+func (db *appdbimpl) HasOIDCIdentity(userID string) (bool, error) {
+	var linked bool
+	err := db.c.QueryRow("SELECT EXISTS (SELECT 1 FROM oidc_identities WHERE user_id = ?)", userID).Scan(&linked)
+	return linked, err
+}
+
+// CreateOIDCUser creates a user for an identity provider subject. It returns
+// ErrUsernameTaken or ErrOIDCIdentityExists when a concurrent login took the username
+// or created the user first.
+func (db *appdbimpl) CreateOIDCUser(userID, username, issuer, subject string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO users (id, username) VALUES (?, ?)", userID, username)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUsernameTaken
+	}
+
+	result, err = tx.Exec("INSERT OR IGNORE INTO oidc_identities (issuer, subject, user_id) VALUES (?, ?, ?)", issuer, subject, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err = result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrOIDCIdentityExists
+	}
+	return tx.Commit()
+}
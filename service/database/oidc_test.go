@@ -0,0 +1,45 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCreateOIDCUserConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		subject  string
+		wantErr  error
+	}{
+		{"new user", "bob", "bob-subject", nil},
+		{"username taken", "alice", "bob-subject", ErrUsernameTaken},
+		{"subject already linked", "bob", "alice-subject", ErrOIDCIdentityExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			if err := db.CreateOIDCUser("alice-id", "alice", "https://idp", "alice-subject"); err != nil {
+				t.Fatal(err)
+			}
+
+			err := db.CreateOIDCUser("bob-id", tt.username, "https://idp", tt.subject)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOIDCUser error = %v, want %v", err, tt.wantErr)
+			}
+
+			// A failed creation leaves nothing behind
+			user, err := db.GetUserByID("bob-id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (user != nil) != (tt.wantErr == nil) {
+				t.Errorf("GetUserByID = %v", user)
+			}
+			userID, err := db.GetOIDCUserID("https://idp", "alice-subject")
+			if err != nil || userID != "alice-id" {
+				t.Errorf("GetOIDCUserID = %q, %v, want alice-id", userID, err)
+			}
+		})
+	}
+}
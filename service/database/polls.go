@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// createPoll stores the poll of a message within the message's transaction
+func createPoll(tx *sql.Tx, messageID string, poll *Poll) error {
+	var closesAt interface{}
+	if poll.ClosesAt != "" {
+		closesAt = poll.ClosesAt
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO polls (message_id, question, multiple_choice, anonymous, closes_at)
+		VALUES (?, ?, ?, ?, datetime(?))
+	`, messageID, poll.Question, poll.MultipleChoice, poll.Anonymous, closesAt)
+	if err != nil {
+		return err
+	}
+
+	for i, option := range poll.Options {
+		_, err = tx.Exec("INSERT INTO poll_options (message_id, position, text) VALUES (?, ?, ?)",
+			messageID, i, option.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPoll loads the poll of a message with its current tallies, or nil if the message has none
+func (db *appdbimpl) getPoll(messageID string) (*Poll, error) {
+	var poll Poll
+	var closesAt sql.NullString
+	err := db.c.QueryRow(`
+		SELECT question, multiple_choice, anonymous, closes_at,
+			closed_at IS NOT NULL OR (closes_at IS NOT NULL AND closes_at <= CURRENT_TIMESTAMP)
+		FROM polls WHERE message_id = ?
+	`, messageID).Scan(&poll.Question, &poll.MultipleChoice, &poll.Anonymous, &closesAt, &poll.Closed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if closesAt.Valid {
+		poll.ClosesAt = closesAt.String
+	}
+
+	rows, err := db.c.Query("SELECT position, text FROM poll_options WHERE message_id = ? ORDER BY position", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var option PollOption
+		if err := rows.Scan(&option.Position, &option.Text); err != nil {
+			return nil, err
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	votes, err := db.c.Query("SELECT position, user_id FROM poll_votes WHERE message_id = ? ORDER BY voted_at", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer votes.Close()
+
+	voters := make(map[string]bool)
+	for votes.Next() {
+		var position int
+		var userID string
+		if err := votes.Scan(&position, &userID); err != nil {
+			return nil, err
+		}
+		if position >= 0 && position < len(poll.Options) {
+			poll.Options[position].VoterIDs = append(poll.Options[position].VoterIDs, userID)
+		}
+		voters[userID] = true
+	}
+	poll.TotalVoters = len(voters)
+
+	return &poll, votes.Err()
+}
+
+// SetPollVote replaces the options a user voted for in a poll
+func (db *appdbimpl) SetPollVote(messageID, userID string, positions []int) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("DELETE FROM poll_votes WHERE message_id = ? AND user_id = ?", messageID, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, position := range positions {
+		_, err = tx.Exec(`
+			INSERT INTO poll_votes (message_id, user_id, position, voted_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`, messageID, userID, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemovePollVote withdraws a user's vote from a poll
+func (db *appdbimpl) RemovePollVote(messageID, userID string) error {
+	result, err := db.c.Exec("DELETE FROM poll_votes WHERE message_id = ? AND user_id = ?", messageID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrVoteNotFound
+	}
+	return nil
+}
+
+// ClosePoll stops a poll from accepting votes
+func (db *appdbimpl) ClosePoll(messageID string) error {
+	_, err := db.c.Exec("UPDATE polls SET closed_at = CURRENT_TIMESTAMP WHERE message_id = ? AND closed_at IS NULL", messageID)
+	return err
+}
+
+// deletePoll removes the poll of a message with its options and votes
+func deletePoll(tx *sql.Tx, messageID string) error {
+	for _, table := range []string{"poll_votes", "poll_options", "polls"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE message_id = ?", messageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetPrivacySettings retrieves a user's privacy settings, with defaults for users who never changed them
+func (db *appdbimpl) GetPrivacySettings(userID string) (*PrivacySettings, error) {
+	settings := PrivacySettings{
+		ForwardAttribution: AudienceEveryone,
+		PhotoVisibility:    AudienceEveryone,
+		GroupAdd:           AudienceEveryone,
+		ReadReceipts:       true,
+		Searchable:         true,
+	}
+	err := db.c.QueryRow(`
+		SELECT forward_attribution, photo_visibility, group_add, read_receipts, searchable
+		FROM privacy_settings WHERE user_id = ?
+	`, userID).Scan(&settings.ForwardAttribution, &settings.PhotoVisibility, &settings.GroupAdd,
+		&settings.ReadReceipts, &settings.Searchable)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdatePrivacySettings stores a user's privacy settings
+func (db *appdbimpl) UpdatePrivacySettings(userID string, settings *PrivacySettings) error {
+	_, err := db.c.Exec(`
+		INSERT INTO privacy_settings (user_id, forward_attribution, photo_visibility, group_add, read_receipts, searchable)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			forward_attribution = excluded.forward_attribution,
+			photo_visibility = excluded.photo_visibility,
+			group_add = excluded.group_add,
+			read_receipts = excluded.read_receipts,
+			searchable = excluded.searchable
+	`, userID, settings.ForwardAttribution, settings.PhotoVisibility, settings.GroupAdd,
+		settings.ReadReceipts, settings.Searchable)
+	return err
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// reportColumns are the columns read by scanReport
+const reportColumns = `id, reporter_id, COALESCE(message_id, ''), COALESCE(conversation_id, ''),
+	reported_user_id, reason, details, snapshot, status, created_at,
+	(SELECT COUNT(*) FROM report_media WHERE report_id = reports.id)`
+
+// CreateReport stores a report with the media of its snapshot
+func (db *appdbimpl) CreateReport(report *Report, media []ReportMedia) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`
+		INSERT INTO reports (id, reporter_id, message_id, conversation_id, reported_user_id, reason, details, snapshot)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)
+	`, report.ID, report.ReporterID, report.MessageID, report.ConversationID, report.ReportedUserID,
+		report.Reason, report.Details, report.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range media {
+		_, err = tx.Exec("INSERT INTO report_media (report_id, position, mime_type, data) VALUES (?, ?, ?, ?)",
+			report.ID, i, m.MimeType, m.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// HasOpenReport reports whether reporterID already has an open report of the message,
+// or of the user when messageID is empty
+func (db *appdbimpl) HasOpenReport(reporterID, messageID, reportedUserID string) (bool, error) {
+	var exists bool
+	err := db.c.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM reports
+			WHERE reporter_id = ? AND COALESCE(message_id, '') = ? AND reported_user_id = ? AND status = ?
+		)
+	`, reporterID, messageID, reportedUserID, ReportOpen).Scan(&exists)
+	return exists, err
+}
+
+// GetReport retrieves a report with its decisions
+func (db *appdbimpl) GetReport(id string) (*Report, error) {
+	report, err := scanReport(db.c.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report.Decisions, err = db.getReportDecisions(id)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetReports lists the reports with the given status, oldest first, with their decisions
+func (db *appdbimpl) GetReports(status string, limit, offset int) ([]Report, error) {
+	rows, err := db.c.Query(`
+		SELECT `+reportColumns+` FROM reports
+		WHERE status = ?
+		ORDER BY created_at, id
+		LIMIT ? OFFSET ?
+	`, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		reports[i].Decisions, err = db.getReportDecisions(reports[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+// GetReportMedia retrieves a media of the snapshot of a report
+func (db *appdbimpl) GetReportMedia(reportID string, position int) (*ReportMedia, error) {
+	var media ReportMedia
+	err := db.c.QueryRow("SELECT mime_type, data FROM report_media WHERE report_id = ? AND position = ?",
+		reportID, position).Scan(&media.MimeType, &media.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+// AddReportDecision records a decision on a report and sets its status
+func (db *appdbimpl) AddReportDecision(reportID string, decision ReportDecision, status string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("INSERT INTO report_decisions (report_id, admin_id, action, note) VALUES (?, ?, ?, ?)",
+		reportID, decision.AdminID, decision.Action, decision.Note)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE reports SET status = ? WHERE id = ?", status, reportID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *appdbimpl) getReportDecisions(reportID string) ([]ReportDecision, error) {
+	rows, err := db.c.Query(`
+		SELECT admin_id, action, note, created_at FROM report_decisions
+		WHERE report_id = ?
+		ORDER BY id
+	`, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []ReportDecision
+	for rows.Next() {
+		var d ReportDecision
+		if err := rows.Scan(&d.AdminID, &d.Action, &d.Note, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
+
+// scanReport reads a row of reportColumns
+func scanReport(row interface{ Scan(...interface{}) error }) (*Report, error) {
+	var r Report
+	err := row.Scan(&r.ID, &r.ReporterID, &r.MessageID, &r.ConversationID, &r.ReportedUserID,
+		&r.Reason, &r.Details, &r.Snapshot, &r.Status, &r.CreatedAt, &r.MediaCount)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
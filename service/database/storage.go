@@ -0,0 +1,62 @@
+package database
+
+// messageSizeExpr is the number of bytes of media stored by a message row aliased as m
+const messageSizeExpr = `COALESCE(LENGTH(m.photo), 0) + COALESCE(LENGTH(m.file_data), 0) +
+	COALESCE((SELECT SUM(LENGTH(mm.photo)) FROM message_media mm WHERE mm.message_id = m.id), 0)`
+
+// GetUserStorage computes how many bytes of media a user has uploaded
+func (db *appdbimpl) GetUserStorage(userID string) (*StorageUsage, error) {
+	usage := StorageUsage{ByType: make(map[string]int64)}
+
+	err := db.c.QueryRow("SELECT COALESCE(LENGTH(photo), 0) FROM users WHERE id = ?", userID).Scan(&usage.ProfilePhoto)
+	if err != nil {
+		return nil, err
+	}
+	usage.Total = usage.ProfilePhoto
+
+	rows, err := db.c.Query(`
+		SELECT conversation_id, type, SUM(size) FROM (
+			SELECT m.conversation_id, m.type, `+messageSizeExpr+` AS size
+			FROM messages m
+			WHERE m.sender_id = ?
+		)
+		WHERE size > 0
+		GROUP BY conversation_id, type
+		ORDER BY conversation_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID, msgType string
+		var size int64
+		if err := rows.Scan(&conversationID, &msgType, &size); err != nil {
+			return nil, err
+		}
+
+		usage.Total += size
+		usage.ByType[msgType] += size
+		if n := len(usage.ByConversation); n > 0 && usage.ByConversation[n-1].ConversationID == conversationID {
+			usage.ByConversation[n-1].Bytes += size
+		} else {
+			usage.ByConversation = append(usage.ByConversation, ConversationStorage{
+				ConversationID: conversationID,
+				Bytes:          size,
+			})
+		}
+	}
+	return &usage, rows.Err()
+}
+
+// GetConversationStorage computes how many bytes of media are stored in a conversation,
+// including the group photo
+func (db *appdbimpl) GetConversationStorage(conversationID string) (int64, error) {
+	var total int64
+	err := db.c.QueryRow(`
+		SELECT COALESCE((SELECT LENGTH(photo) FROM conversations WHERE id = ?), 0) +
+			COALESCE((SELECT SUM(`+messageSizeExpr+`) FROM messages m WHERE m.conversation_id = ?), 0)
+	`, conversationID, conversationID).Scan(&total)
+	return total, err
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetTOTP retrieves a user's two-factor secret, or nil if the user never enrolled
+func (db *appdbimpl) GetTOTP(userID string) (*TOTP, error) {
+	var t TOTP
+	err := db.c.QueryRow(`
+		SELECT secret, enabled,
+			(SELECT COUNT(*) FROM recovery_codes WHERE user_id = totp.user_id AND used_at IS NULL)
+		FROM totp WHERE user_id = ?
+	`, userID).Scan(&t.Secret, &t.Enabled, &t.RecoveryCodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SetTOTPSecret starts an enrolment with a new secret, replacing an unconfirmed one
+func (db *appdbimpl) SetTOTPSecret(userID, secret string) error {
+	_, err := db.c.Exec(`
+		INSERT INTO totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret,
+			enabled = 0,
+			last_step = 0,
+			created_at = CURRENT_TIMESTAMP
+		WHERE enabled = 0
+	`, userID, secret)
+	return err
+}
+
+// EnableTOTP confirms an enrolment and replaces the user's recovery codes
+func (db *appdbimpl) EnableTOTP(userID string, recoveryCodeHashes []string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE totp SET enabled = 1 WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code of a time step was used. It returns false when
+// that step or a later one was already used, so that a code works only once.
+func (db *appdbimpl) UseTOTPStep(userID string, step int64) (bool, error) {
+	result, err := db.c.Exec("UPDATE totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode marks a recovery code as used, returning false if it is unknown or already used
+func (db *appdbimpl) UseRecoveryCode(userID, codeHash string) (bool, error) {
+	result, err := db.c.Exec(`
+		UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteTOTP turns off two-factor authentication, removing the secret and recovery codes
+func (db *appdbimpl) DeleteTOTP(userID string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
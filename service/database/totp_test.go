@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDatabase returns an AppDatabase on an empty SQLite file removed after the test
+func newTestDatabase(t *testing.T) AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUseTOTPStepRejectsReplay(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.CreateUser("user", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret("user", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps are used in order, each one is accepted only if later than the last used
+	tests := []struct {
+		name string
+		step int64
+		want bool
+	}{
+		{"first code", 100, true},
+		{"same code again", 100, false},
+		{"earlier code", 99, false},
+		{"next code", 101, true},
+		{"code skipping ahead", 103, true},
+		{"code from the skipped step", 102, false},
+	}
+	for _, tt := range tests {
+		got, err := db.UseTOTPStep("user", tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: UseTOTPStep(%d) = %v, want %v", tt.name, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestUseTOTPStepWithoutEnrolment(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.CreateUser("user", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := db.UseTOTPStep("user", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("UseTOTPStep accepted a step for a user without a secret")
+	}
+}
+
+func TestSetTOTPSecretResetsLastStep(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.CreateUser("user", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret("user", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.UseTOTPStep("user", 100); err != nil || !ok {
+		t.Fatalf("UseTOTPStep = %v, %v", ok, err)
+	}
+
+	// Restarting an unconfirmed enrolment starts over with the new secret
+	if err := db.SetTOTPSecret("user", "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.UseTOTPStep("user", 50); err != nil || !ok {
+		t.Errorf("UseTOTPStep after a new secret = %v, %v, want true", ok, err)
+	}
+}
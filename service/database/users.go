@@ -5,6 +5,21 @@ import (
 	"errors"
 )
 
+// userColumns are the columns read by scanUser
+const userColumns = "id, username, photo, bot, COALESCE(owner_id, ''), admin, suspended_at"
+
+// scanUser reads a row of userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	var suspendedAt sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.Photo, &user.Bot, &user.OwnerID, &user.Admin, &suspendedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.SuspendedAt = suspendedAt.String
+	return &user, nil
+}
+
 // CreateUser creates a new user
 func (db *appdbimpl) CreateUser(id, username string) error {
 	_, err := db.c.Exec("INSERT INTO users (id, username) VALUES (?, ?)", id, username)
@@ -13,28 +28,20 @@ func (db *appdbimpl) CreateUser(id, username string) error {
 
 // GetUserByID retrieves a user by their ID
 func (db *appdbimpl) GetUserByID(id string) (*User, error) {
-	var user User
-	err := db.c.QueryRow("SELECT id, username, photo FROM users WHERE id = ?", id).Scan(&user.ID, &user.Username, &user.Photo)
+	user, err := scanUser(db.c.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return user, err
 }
 
 // GetUserByUsername retrieves a user by their username
 func (db *appdbimpl) GetUserByUsername(username string) (*User, error) {
-	var user User
-	err := db.c.QueryRow("SELECT id, username, photo FROM users WHERE username = ?", username).Scan(&user.ID, &user.Username, &user.Photo)
+	user, err := scanUser(db.c.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return user, err
 }
 
 // UpdateUsername updates a user's username
@@ -69,9 +76,16 @@ func (db *appdbimpl) UpdateUserPhoto(userID string, photo []byte) error {
 	return nil
 }
 
-// SearchUsers searches for users by username substring
-func (db *appdbimpl) SearchUsers(query string) ([]User, error) {
-	rows, err := db.c.Query("SELECT id, username, photo FROM users WHERE username LIKE ? LIMIT 50", "%"+query+"%")
+// SearchUsers searches for users by username substring, leaving out users who blocked viewerID
+// or chose not to appear in searches
+func (db *appdbimpl) SearchUsers(query, viewerID string) ([]User, error) {
+	rows, err := db.c.Query(`
+		SELECT id, username, photo, bot FROM users
+		WHERE username LIKE ?
+			AND id NOT IN (SELECT blocker_id FROM blocks WHERE blocked_id = ?)
+			AND id NOT IN (SELECT user_id FROM privacy_settings WHERE searchable = 0)
+		LIMIT 50
+	`, "%"+query+"%", viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +94,7 @@ func (db *appdbimpl) SearchUsers(query string) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Photo); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Photo, &user.Bot); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
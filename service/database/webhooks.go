@@ -0,0 +1,250 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// IsGroupAdmin checks if a user administers a group
+func (db *appdbimpl) IsGroupAdmin(groupID, userID string) (bool, error) {
+	var admin bool
+	err := db.c.QueryRow("SELECT admin FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+		groupID, userID).Scan(&admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return admin, err
+}
+
+// webhookManager holds for the webhooks w whose creator can still manage them: they
+// administer the group, or are a member of the conversation along with one of their bots
+const webhookManager = `EXISTS (
+	SELECT 1 FROM conversation_members cm
+	INNER JOIN conversations c ON c.id = cm.conversation_id
+	WHERE cm.conversation_id = w.conversation_id AND cm.user_id = w.created_by AND (
+		(c.type = 'group' AND cm.admin = 1) OR EXISTS (
+			SELECT 1 FROM conversation_members b
+			INNER JOIN users u ON u.id = b.user_id
+			WHERE b.conversation_id = cm.conversation_id AND u.bot = 1 AND u.owner_id = cm.user_id
+		)
+	)
+)`
+
+// IsWebhookManager checks if a user can manage the webhooks of a conversation
+func (db *appdbimpl) IsWebhookManager(conversationID, userID string) (bool, error) {
+	var manager bool
+	err := db.c.QueryRow("SELECT EXISTS(SELECT 1 FROM (SELECT ? AS conversation_id, ? AS created_by) w WHERE "+webhookManager+")",
+		conversationID, userID).Scan(&manager)
+	return manager, err
+}
+
+// deleteUnmanagedWebhooks deletes the webhooks of a conversation, with their deliveries,
+// whose creator can no longer manage them, and the incoming webhooks whose creator is
+// no longer an admin of the group
+func deleteUnmanagedWebhooks(ex interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, conversationID string) error {
+	unmanaged := "SELECT id FROM webhooks w WHERE w.conversation_id = ? AND NOT " + webhookManager
+	if _, err := ex.Exec("DELETE FROM webhook_deliveries WHERE webhook_id IN ("+unmanaged+")", conversationID); err != nil {
+		return err
+	}
+	if _, err := ex.Exec("DELETE FROM webhooks WHERE id IN ("+unmanaged+")", conversationID); err != nil {
+		return err
+	}
+	_, err := ex.Exec(`
+		DELETE FROM incoming_webhooks
+		WHERE conversation_id = ? AND created_by NOT IN (
+			SELECT user_id FROM conversation_members WHERE conversation_id = ? AND admin = 1
+		)
+	`, conversationID, conversationID)
+	return err
+}
+
+// CreateWebhook registers a webhook
+func (db *appdbimpl) CreateWebhook(webhook *Webhook) error {
+	_, err := db.c.Exec(`
+		INSERT INTO webhooks (id, conversation_id, created_by, url, secret, events)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, webhook.ID, webhook.ConversationID, webhook.CreatedBy, webhook.URL, webhook.Secret, strings.Join(webhook.Events, " "))
+	return err
+}
+
+// GetWebhook retrieves a webhook by ID
+func (db *appdbimpl) GetWebhook(id string) (*Webhook, error) {
+	webhook, err := scanWebhook(db.c.QueryRow(`
+		SELECT id, conversation_id, created_by, url, secret, events, created_at
+		FROM webhooks WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+// GetWebhooks retrieves the webhooks of a conversation, oldest first
+func (db *appdbimpl) GetWebhooks(conversationID string) ([]Webhook, error) {
+	rows, err := db.c.Query(`
+		SELECT id, conversation_id, created_by, url, secret, events, created_at
+		FROM webhooks WHERE conversation_id = ?
+		ORDER BY created_at, rowid
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (db *appdbimpl) DeleteWebhook(id string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateWebhookDeliveries queues deliveries to be sent as soon as possible
+func (db *appdbimpl) CreateWebhookDeliveries(deliveries []WebhookDelivery) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, d := range deliveries {
+		_, err := tx.Exec(`
+			INSERT INTO webhook_deliveries (id, webhook_id, event, payload) VALUES (?, ?, ?, ?)
+		`, d.ID, d.WebhookID, d.Event, d.Payload)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetWebhookDelivery retrieves a delivery by ID
+func (db *appdbimpl) GetWebhookDelivery(id string) (*WebhookDelivery, error) {
+	delivery, err := scanWebhookDelivery(db.c.QueryRow(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// GetWebhookDeliveries retrieves the latest deliveries of a webhook, newest first
+func (db *appdbimpl) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return db.queryWebhookDeliveries(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ?
+	`, webhookID, limit)
+}
+
+// GetDueWebhookDeliveries retrieves the pending deliveries whose next attempt is due, oldest first
+func (db *appdbimpl) GetDueWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	return db.queryWebhookDeliveries(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY next_attempt_at, rowid
+		LIMIT ?
+	`, limit)
+}
+
+// RecordWebhookAttempt stores the outcome of an attempt to send a delivery. A pending
+// delivery is attempted again at nextAttemptAt.
+func (db *appdbimpl) RecordWebhookAttempt(id string, statusCode int, attemptErr string, status, nextAttemptAt string) error {
+	var next interface{}
+	if nextAttemptAt != "" {
+		next = nextAttemptAt
+	}
+	_, err := db.c.Exec(`
+		UPDATE webhook_deliveries SET
+			attempts = attempts + 1,
+			last_status_code = ?,
+			last_error = ?,
+			status = ?,
+			next_attempt_at = datetime(?),
+			delivered_at = CASE WHEN ? = 'delivered' THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = ?
+	`, statusCode, attemptErr, status, next, status, id)
+	return err
+}
+
+// DeleteWebhookDeliveries deletes the delivered and failed deliveries created before
+// the given time
+func (db *appdbimpl) DeleteWebhookDeliveries(before string) error {
+	_, err := db.c.Exec("DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < datetime(?)", before)
+	return err
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+func (db *appdbimpl) queryWebhookDeliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(&webhook.ID, &webhook.ConversationID, &webhook.CreatedBy, &webhook.URL, &webhook.Secret,
+		&events, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = strings.Fields(events)
+	return &webhook, nil
+}
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var nextAttemptAt, deliveredAt sql.NullString
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &nextAttemptAt,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = nextAttemptAt.String
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = deliveredAt.String
+	}
+	return &d, nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newWebhookGroup returns a database with a group administered by "admin", where
+// "member" is a member along with their bot "bot", and "owner" has a bot in the group
+// without being a member. Each of them created a webhook for the group.
+func newWebhookGroup(t *testing.T) AppDatabase {
+	t.Helper()
+	db := newTestDatabase(t)
+	for _, id := range []string{"admin", "member", "owner", "other"} {
+		if err := db.CreateUser(id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, owner := range map[string]string{"bot": "member", "ownerbot": "owner"} {
+		if err := db.CreateBot(id, id, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateGroupConversation("group", "Group", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"member", "bot", "ownerbot", "other"} {
+		if err := db.AddGroupMember("group", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"admin", "member", "owner", "other"} {
+		err := db.CreateWebhook(&Webhook{ID: "hook-" + id, ConversationID: "group", CreatedBy: id, URL: "https://example.com", Secret: "s"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestIsWebhookManager(t *testing.T) {
+	db := newWebhookGroup(t)
+	tests := []struct {
+		userID string
+		want   bool
+	}{
+		{"admin", true},
+		{"member", true}, // with their bot
+		{"owner", false}, // their bot is in the group, they aren't
+		{"other", false},
+		{"bot", false},
+		{"stranger", false},
+	}
+	for _, tt := range tests {
+		got, err := db.IsWebhookManager("group", tt.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("IsWebhookManager(%s) = %v, want %v", tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestLosingManagementDeletesWebhooks(t *testing.T) {
+	// Webhooks created by users who couldn't manage them are swept along
+	tests := []struct {
+		name   string
+		change func(db AppDatabase) error
+		kept   []string
+	}{
+		{
+			name:   "admin leaves",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "admin") },
+			kept:   []string{"hook-member"},
+		},
+		{
+			name: "admin demoted",
+			change: func(db AppDatabase) error {
+				if err := db.SetGroupAdmin("group", "other", true); err != nil {
+					return err
+				}
+				return db.SetGroupAdmin("group", "admin", false)
+			},
+			kept: []string{"hook-member", "hook-other"},
+		},
+		{
+			name:   "bot owner leaves",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "member") },
+			kept:   []string{"hook-admin"},
+		},
+		{
+			name:   "bot removed",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "bot") },
+			kept:   []string{"hook-admin"},
+		},
+		{
+			name:   "other member leaves",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "other") },
+			kept:   []string{"hook-admin", "hook-member"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newWebhookGroup(t)
+			if err := tt.change(db); err != nil {
+				t.Fatal(err)
+			}
+
+			webhooks, err := db.GetWebhooks("group")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, webhook := range webhooks {
+				kept = append(kept, webhook.ID)
+			}
+			sort.Strings(kept)
+			if strings.Join(kept, " ") != strings.Join(tt.kept, " ") {
+				t.Errorf("webhooks kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestFailedDemotionKeepsWebhooks(t *testing.T) {
+	db := newWebhookGroup(t)
+	if err := db.SetGroupAdmin("group", "admin", false); !errors.Is(err, ErrLastGroupAdmin) {
+		t.Fatalf("SetGroupAdmin error = %v, want ErrLastGroupAdmin", err)
+	}
+	if webhook, err := db.GetWebhook("hook-admin"); err != nil || webhook == nil {
+		t.Errorf("GetWebhook = %v, %v, want the webhook of the admin", webhook, err)
+	}
+}
+
+func TestLosingAdminDeletesIncomingWebhooks(t *testing.T) {
+	// Incoming webhooks post as their creator, who must stay a group admin
+	tests := []struct {
+		name   string
+		change func(db AppDatabase) error
+		kept   []string
+	}{
+		{
+			name:   "admin leaves",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "admin") },
+			kept:   []string{"in-other"},
+		},
+		{
+			name:   "admin demoted",
+			change: func(db AppDatabase) error { return db.SetGroupAdmin("group", "admin", false) },
+			kept:   []string{"in-other"},
+		},
+		{
+			name:   "member leaves",
+			change: func(db AppDatabase) error { return db.RemoveGroupMember("group", "member") },
+			kept:   []string{"in-admin", "in-other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newWebhookGroup(t)
+			if err := db.SetGroupAdmin("group", "other", true); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"admin", "other"} {
+				hook := &IncomingWebhook{ID: "in-" + id, ConversationID: "group", CreatedBy: id, Name: id, Prefix: id}
+				if err := db.CreateIncomingWebhook(hook, "hash-"+id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tt.change(db); err != nil {
+				t.Fatal(err)
+			}
+
+			hooks, err := db.GetIncomingWebhooks("group")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, hook := range hooks {
+				kept = append(kept, hook.ID)
+			}
+			sort.Strings(kept)
+			if strings.Join(kept, " ") != strings.Join(tt.kept, " ") {
+				t.Errorf("incoming webhooks kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Package media inspects uploaded media files. It only reads container headers and
+never decodes the payload, so it is cheap enough to run on every upload.
+*/
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrUnsupportedAudio is returned when the data is not a supported audio container
+var ErrUnsupportedAudio = errors.New("unsupported audio format")
+
+// ErrMalformedAudio is returned when the container is recognized but its header is damaged
+var ErrMalformedAudio = errors.New("malformed audio container")
+
+// ErrAudioTooLong is returned when the header claims a duration above MaxAudioDuration
+var ErrAudioTooLong = errors.New("audio too long")
+
+// MaxAudioDuration is the longest duration accepted. Headers are not checked against
+// the payload, so this also keeps forged values within what the database can store.
+const MaxAudioDuration = 24 * time.Hour
+
+// AudioInfo describes an audio file
+type AudioInfo struct {
+	MimeType string
+	Duration time.Duration
+}
+
+// ParseAudio detects the container of an Ogg/Opus, WAV or M4A file and reads its duration
+func ParseAudio(data []byte) (*AudioInfo, error) {
+	switch {
+	case len(data) >= 4 && bytes.Equal(data[:4], []byte("OggS")):
+		d, err := oggOpusDuration(data)
+		if err != nil {
+			return nil, err
+		}
+		return &AudioInfo{MimeType: "audio/ogg", Duration: d}, nil
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		d, err := wavDuration(data)
+		if err != nil {
+			return nil, err
+		}
+		return &AudioInfo{MimeType: "audio/wav", Duration: d}, nil
+	case len(data) >= 8 && bytes.Equal(data[4:8], []byte("ftyp")):
+		d, err := mp4Duration(data)
+		if err != nil {
+			return nil, err
+		}
+		return &AudioInfo{MimeType: "audio/mp4", Duration: d}, nil
+	}
+	return nil, ErrUnsupportedAudio
+}
+
+// oggOpusDuration reads the pre-skip from the OpusHead packet and the granule
+// position of the last page. Opus granule positions always count 48 kHz samples.
+func oggOpusDuration(data []byte) (time.Duration, error) {
+	const pageHeaderSize = 27
+	if len(data) < pageHeaderSize {
+		return 0, ErrMalformedAudio
+	}
+	segments := int(data[26])
+	bodyStart := pageHeaderSize + segments
+	if len(data) < bodyStart+19 {
+		return 0, ErrMalformedAudio
+	}
+	if !bytes.Equal(data[bodyStart:bodyStart+8], []byte("OpusHead")) {
+		return 0, ErrUnsupportedAudio
+	}
+	serial := binary.LittleEndian.Uint32(data[14:18])
+	preSkip := int64(binary.LittleEndian.Uint16(data[bodyStart+10 : bodyStart+12]))
+
+	// Walk back from the end to the last page of the Opus stream
+	for i := bytes.LastIndex(data, []byte("OggS")); i >= 0; i = bytes.LastIndex(data[:i], []byte("OggS")) {
+		if len(data) < i+pageHeaderSize || data[i+4] != 0 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(data[i+14:i+18]) != serial {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(data[i+6 : i+14]))
+		if granule < 0 {
+			continue
+		}
+		samples := granule - preSkip
+		if samples < 0 {
+			samples = 0
+		}
+		return scaleDuration(uint64(samples), 48000)
+	}
+	return 0, ErrMalformedAudio
+}
+
+// wavDuration divides the size of the data chunk by the byte rate from the fmt chunk
+func wavDuration(data []byte) (time.Duration, error) {
+	var byteRate uint32
+	var dataSize int64 = -1
+
+	for pos := 12; pos+8 <= len(data); {
+		id := data[pos : pos+4]
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+
+		switch {
+		case bytes.Equal(id, []byte("fmt ")):
+			if size < 16 || int64(len(data)) < int64(body)+16 {
+				return 0, ErrMalformedAudio
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+		case bytes.Equal(id, []byte("data")):
+			// Streaming encoders may leave the size unset, fall back to what was uploaded
+			available := int64(len(data) - body)
+			if size == 0 || size > available {
+				size = available
+			}
+			dataSize = size
+		}
+
+		if dataSize >= 0 && byteRate > 0 {
+			return scaleDuration(uint64(dataSize), uint64(byteRate))
+		}
+
+		// Chunks are padded to an even size
+		next := int64(body) + size + size%2
+		if next > int64(len(data)) {
+			break
+		}
+		pos = int(next)
+	}
+	return 0, ErrMalformedAudio
+}
+
+// mp4Duration reads the timescale and duration from the movie header box (moov/mvhd)
+func mp4Duration(data []byte) (time.Duration, error) {
+	moov, ok := findBox(data, "moov")
+	if !ok {
+		return 0, ErrMalformedAudio
+	}
+	mvhd, ok := findBox(moov, "mvhd")
+	if !ok || len(mvhd) < 4 {
+		return 0, ErrMalformedAudio
+	}
+
+	var timescale uint32
+	var duration uint64
+	switch mvhd[0] {
+	case 0:
+		if len(mvhd) < 20 {
+			return 0, ErrMalformedAudio
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	case 1:
+		if len(mvhd) < 32 {
+			return 0, ErrMalformedAudio
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	default:
+		return 0, ErrMalformedAudio
+	}
+	if timescale == 0 {
+		return 0, ErrMalformedAudio
+	}
+	return scaleDuration(duration, uint64(timescale))
+}
+
+// scaleDuration converts a count of units at the given rate per second to a duration
+// with millisecond precision. The whole seconds are checked against MaxAudioDuration
+// before scaling, so forged header values can't overflow.
+func scaleDuration(count, rate uint64) (time.Duration, error) {
+	seconds := count / rate
+	if seconds > uint64(MaxAudioDuration/time.Second) {
+		return 0, ErrAudioTooLong
+	}
+	ms := seconds*1000 + count%rate*1000/rate
+	if time.Duration(ms)*time.Millisecond > MaxAudioDuration {
+		return 0, ErrAudioTooLong
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// findBox returns the payload of the first ISO BMFF box of the given type at this level
+func findBox(data []byte, boxType string) ([]byte, bool) {
+	for pos := 0; pos+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if pos+16 > len(data) {
+				return nil, false
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-pos) {
+			return nil, false
+		}
+		if string(data[pos+4:pos+8]) == boxType {
+			return data[uint64(pos)+header : uint64(pos)+size], true
+		}
+		pos += int(size)
+	}
+	return nil, false
+}
@@ -0,0 +1,164 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// oggPage returns an Ogg page of a stream with a single segment holding body
+func oggPage(serial uint32, granule int64, body []byte) []byte {
+	page := make([]byte, 27, 28+len(body))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint64(page[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	page[26] = 1
+	page = append(page, byte(len(body)))
+	return append(page, body...)
+}
+
+// opusHead returns an OpusHead packet with the given pre-skip
+func opusHead(preSkip uint16) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = 1 // channels
+	binary.LittleEndian.PutUint16(head[10:12], preSkip)
+	binary.LittleEndian.PutUint32(head[12:16], 48000)
+	return head
+}
+
+// ogg returns an Opus stream whose last page has the given granule position
+func ogg(preSkip uint16, granule int64) []byte {
+	data := oggPage(1, 0, opusHead(preSkip))
+	data = append(data, oggPage(1, 0, []byte("OpusTags"))...)
+	return append(data, oggPage(1, granule, []byte("audio"))...)
+}
+
+// wavChunk returns a RIFF chunk, padded to an even size
+func wavChunk(id string, size uint32, body []byte) []byte {
+	chunk := make([]byte, 8, 9+len(body))
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:8], size)
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// wav returns a WAV file with the given byte rate and data chunk
+func wav(byteRate uint32, dataSize uint32, payload int) []byte {
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:2], 1) // PCM
+	binary.LittleEndian.PutUint16(format[2:4], 1) // channels
+	binary.LittleEndian.PutUint32(format[8:12], byteRate)
+
+	data := []byte("RIFF\x00\x00\x00\x00WAVE")
+	data = append(data, wavChunk("fmt ", 16, format)...)
+	return append(data, wavChunk("data", dataSize, make([]byte, payload))...)
+}
+
+// box returns an ISO BMFF box
+func box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(8+len(body)))
+	copy(b[4:8], boxType)
+	return append(b, body...)
+}
+
+// m4a returns an M4A file with a version 0 movie header
+func m4a(timescale, duration uint32) []byte {
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:16], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:20], duration)
+	return append(box("ftyp", []byte("M4A \x00\x00\x00\x00")), box("moov", box("mvhd", mvhd))...)
+}
+
+// m4a64 returns an M4A file with a version 1 movie header, which has 64 bit durations
+func m4a64(timescale uint32, duration uint64) []byte {
+	mvhd := make([]byte, 32)
+	mvhd[0] = 1
+	binary.BigEndian.PutUint32(mvhd[20:24], timescale)
+	binary.BigEndian.PutUint64(mvhd[24:32], duration)
+	return append(box("ftyp", []byte("M4A \x00\x00\x00\x00")), box("moov", box("mvhd", mvhd))...)
+}
+
+func TestParseAudio(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		duration time.Duration
+		err      error
+	}{
+		{"ogg", ogg(312, 48000*5+312), "audio/ogg", 5 * time.Second, nil},
+		{"ogg milliseconds", ogg(0, 48000+48*250+24), "audio/ogg", 1250 * time.Millisecond, nil},
+		{"ogg shorter than pre-skip", ogg(312, 100), "audio/ogg", 0, nil},
+		{"ogg at the maximum", ogg(0, int64(MaxAudioDuration/time.Second)*48000), "audio/ogg", MaxAudioDuration, nil},
+		{"ogg too long", ogg(0, int64(MaxAudioDuration/time.Second)*48000+48), "", 0, ErrAudioTooLong},
+		{"ogg largest granule", ogg(0, 1<<63-1), "", 0, ErrAudioTooLong},
+		{
+			"ogg last page without a granule",
+			append(ogg(0, 48000), oggPage(1, -1, []byte("audio"))...),
+			"audio/ogg", time.Second, nil,
+		},
+		{"ogg other codec", oggPage(1, 0, append([]byte("\x01vorbis"), make([]byte, 23)...)), "", 0, ErrUnsupportedAudio},
+		{"ogg truncated", []byte("OggS\x00\x02"), "", 0, ErrMalformedAudio},
+		{
+			"ogg other stream last",
+			append(ogg(0, 48000*2), oggPage(2, 48000*60, []byte("video"))...),
+			"audio/ogg", 2 * time.Second, nil,
+		},
+
+		{"wav", wav(16000, 32000, 32000), "audio/wav", 2 * time.Second, nil},
+		{"wav milliseconds", wav(16000, 16016, 16016), "audio/wav", 1001 * time.Millisecond, nil},
+		{"wav unset data size", wav(8000, 0, 4000), "audio/wav", 500 * time.Millisecond, nil},
+		{"wav data size past the end", wav(8000, 0xffffffff, 8000), "audio/wav", time.Second, nil},
+		{"wav without byte rate", wav(0, 100, 100), "", 0, ErrMalformedAudio},
+		{"wav at the maximum", wav(1, 0, int(MaxAudioDuration/time.Second)), "audio/wav", MaxAudioDuration, nil},
+		{"wav too long", wav(1, 0, int(MaxAudioDuration/time.Second)+1), "", 0, ErrAudioTooLong},
+		{"wav without chunks", []byte("RIFF\x00\x00\x00\x00WAVE"), "", 0, ErrMalformedAudio},
+
+		{"m4a", m4a(1000, 90500), "audio/mp4", 90*time.Second + 500*time.Millisecond, nil},
+		{"m4a sample rate timescale", m4a(44100, 44100*3+441), "audio/mp4", 3*time.Second + 10*time.Millisecond, nil},
+		{"m4a version 1", m4a64(48000, 48000*60), "audio/mp4", time.Minute, nil},
+		{"m4a at the maximum", m4a64(1, uint64(MaxAudioDuration/time.Second)), "audio/mp4", MaxAudioDuration, nil},
+		{"m4a too long", m4a64(1000, uint64(MaxAudioDuration/time.Millisecond)+1), "", 0, ErrAudioTooLong},
+		{"m4a largest duration", m4a64(1, 1<<64-1), "", 0, ErrAudioTooLong},
+		{"m4a largest duration and timescale", m4a64(1<<32-1, 1<<64-1), "", 0, ErrAudioTooLong},
+		{"m4a zero timescale", m4a(0, 1000), "", 0, ErrMalformedAudio},
+		{"m4a without moov", box("ftyp", []byte("M4A ")), "", 0, ErrMalformedAudio},
+		{
+			"m4a box larger than the file",
+			append(box("ftyp", []byte("M4A ")), 0xff, 0xff, 0xff, 0xff, 'm', 'o', 'o', 'v'),
+			"", 0, ErrMalformedAudio,
+		},
+
+		{"empty", nil, "", 0, ErrUnsupportedAudio},
+		{"mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), "", 0, ErrUnsupportedAudio},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParseAudio(tt.data)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ParseAudio error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAudio: %v", err)
+			}
+			if info.MimeType != tt.mimeType {
+				t.Errorf("MimeType = %s, want %s", info.MimeType, tt.mimeType)
+			}
+			if info.Duration != tt.duration {
+				t.Errorf("Duration = %s, want %s", info.Duration, tt.duration)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// Register the decoders for the formats accepted as photos
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ThumbnailSize is the length in pixels of the longest side of a thumbnail
+const ThumbnailSize = 256
+
+// MaxThumbnailPixels is the largest image, in pixels, that Thumbnail decodes. A few
+// kilobytes of compressed data can claim a huge canvas, and decoding allocates it all.
+const MaxThumbnailPixels = 50_000_000
+
+// ErrImageTooLarge is returned for images with more than MaxThumbnailPixels pixels
+var ErrImageTooLarge = errors.New("image too large")
+
+// Thumbnail decodes a JPEG, PNG or GIF image and returns a JPEG scaled down so that
+// its longest side is at most maxSide pixels. Smaller images are only re-encoded.
+func Thumbnail(data []byte, maxSide int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("empty image")
+	}
+	if config.Width > MaxThumbnailPixels/config.Height {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			height = max(1, height*maxSide/width)
+			width = maxSide
+		} else {
+			width = max(1, width*maxSide/height)
+			height = maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaleBox(dst, src)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleBox fills dst by averaging the source pixels covered by each destination pixel
+func scaleBox(dst *image.RGBA, src image.Image) {
+	sb := src.Bounds()
+	db := dst.Bounds()
+
+	for y := 0; y < db.Dy(); y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/db.Dy()
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sb.Dy()/db.Dy())
+		for x := 0; x < db.Dx(); x++ {
+			x0 := sb.Min.X + x*sb.Dx()/db.Dx()
+			x1 := max(x0+1, sb.Min.X+(x+1)*sb.Dx()/db.Dx())
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			// Composite the premultiplied average over white, JPEG has no alpha channel
+			bg := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r/n + bg),
+				G: uint16(g/n + bg),
+				B: uint16(b/n + bg),
+				A: 0xffff,
+			})
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testClientID = "wasatext"
+	testNonce    = "nonce"
+)
+
+// testKeys are the provider keys the tokens of the tests are signed with
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	other *rsa.PrivateKey // not published by the provider
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey, other: other}
+}
+
+// newTestProvider returns a provider with the keys already fetched, so that an
+// unknown key ID fails without contacting the provider
+func newTestProvider(keys *testKeys) *Provider {
+	p := New(Config{Issuer: testIssuer, ClientID: testClientID})
+	p.keys.keys = map[string]crypto.PublicKey{
+		"rsa": &keys.rsa.PublicKey,
+		"ec":  &keys.ec.PublicKey,
+	}
+	p.keys.fetched = time.Now()
+	return p
+}
+
+// signToken returns a token with the header and claims, signed with key according
+// to alg. An unsupported alg is signed as HS256 with an empty secret.
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		mac := hmac.New(sha256.New, nil)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		alg     string
+		kid     string
+		key     interface{}
+		claims  func(c map[string]interface{}) // changes to valid claims
+		wantErr bool
+	}{
+		{name: "valid RS256", alg: "RS256", kid: "rsa", key: keys.rsa},
+		{name: "valid ES256", alg: "ES256", kid: "ec", key: keys.ec},
+		{
+			name: "several audiences, authorized party is the client",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims: func(c map[string]interface{}) {
+				c["aud"] = []string{testClientID, "other"}
+				c["azp"] = testClientID
+			},
+		},
+		{
+			name: "expired within the clock skew",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims: func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() },
+		},
+		{
+			name: "issued in the future within the clock skew",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims: func(c map[string]interface{}) { c["iat"] = now.Add(30 * time.Second).Unix() },
+		},
+
+		{name: "alg none", alg: "none", kid: "rsa", wantErr: true},
+		{name: "alg HS256", alg: "HS256", kid: "rsa", wantErr: true},
+		{name: "ES256 with an RSA key", alg: "ES256", kid: "rsa", key: keys.ec, wantErr: true},
+		{name: "RS256 with an EC key", alg: "RS256", kid: "ec", key: keys.rsa, wantErr: true},
+		{name: "unknown kid", alg: "RS256", kid: "unknown", key: keys.rsa, wantErr: true},
+		{name: "signed with another key", alg: "RS256", kid: "rsa", key: keys.other, wantErr: true},
+		{
+			name: "wrong issuer",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["aud"] = "other" },
+			wantErr: true,
+		},
+		{
+			name: "several audiences, wrong authorized party",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims: func(c map[string]interface{}) {
+				c["aud"] = []string{testClientID, "other"}
+				c["azp"] = "other"
+			},
+			wantErr: true,
+		},
+		{
+			name: "several audiences, no authorized party",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} },
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+			wantErr: true,
+		},
+		{
+			name: "issued in the future",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["iat"] = now.Add(2 * time.Minute).Unix() },
+			wantErr: true,
+		},
+		{
+			name: "wrong nonce",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { c["nonce"] = "other" },
+			wantErr: true,
+		},
+		{
+			name: "no nonce",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { delete(c, "nonce") },
+			wantErr: true,
+		},
+		{
+			name: "no subject",
+			alg:  "RS256", kid: "rsa", key: keys.rsa,
+			claims:  func(c map[string]interface{}) { delete(c, "sub") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]interface{}{
+				"iss":                testIssuer,
+				"sub":                "subject",
+				"aud":                testClientID,
+				"exp":                now.Add(time.Hour).Unix(),
+				"iat":                now.Unix(),
+				"nonce":              testNonce,
+				"preferred_username": "alice",
+			}
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			token := signToken(t, tt.alg, tt.kid, tt.key, claims)
+
+			got, err := newTestProvider(keys).verify(context.Background(), &discovery{Issuer: testIssuer}, token, testNonce)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("verify error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Issuer != testIssuer || got.Subject != "subject" || got.PreferredUsername != "alice" {
+				t.Errorf("verify = %+v", got)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	p := newTestProvider(newTestKeys(t))
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "e30.e30.e30"} {
+		if _, err := p.verify(context.Background(), &discovery{Issuer: testIssuer}, token, testNonce); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("verify(%q) error = %v, want ErrInvalidToken", token, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Package oidc implements login through an OpenID Connect identity provider with the
+authorization code flow and PKCE. The provider is configured through discovery and
+ID tokens are verified against its published keys.
+*/
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginTimeout is how long a started login can be completed
+const LoginTimeout = 10 * time.Minute
+
+// maxResponseSize bounds the documents read from the provider
+const maxResponseSize = 1 << 20
+
+// ErrInvalidState is returned for a callback that does not match a pending login,
+// because it expired, was already completed, was never started here or was started
+// in another browser
+var ErrInvalidState = errors.New("unknown or expired login")
+
+// ErrInvalidToken is returned when the ID token fails verification
+var ErrInvalidToken = errors.New("invalid ID token")
+
+// Config identifies this application to the identity provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients
+	RedirectURL  string
+	Scopes       []string // requested in addition to openid
+}
+
+// Claims are the identity claims of a verified ID token
+type Claims struct {
+	Issuer            string
+	Subject           string
+	PreferredUsername string
+	Email             string
+	Name              string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type pendingLogin struct {
+	binding  string
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// Provider runs logins against one identity provider. It is safe for concurrent use.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+	pending   map[string]pendingLogin
+}
+
+// New returns a provider for cfg. The provider is contacted on the first login.
+func New(cfg Config) *Provider {
+	return &Provider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keys:    &keySet{},
+		pending: make(map[string]pendingLogin),
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to send the user to, and a
+// binding value to keep in the browser, e.g. in a cookie. Exchange requires the same
+// binding, so that the login can only be completed in the browser that started it.
+func (p *Provider) AuthCodeURL(ctx context.Context) (authURL, binding string, err error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	var state, verifier, nonce string
+	for _, s := range []*string{&state, &binding, &verifier, &nonce} {
+		if *s, err = randomString(); err != nil {
+			return "", "", err
+		}
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for s, login := range p.pending {
+		if now.After(login.expires) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = pendingLogin{binding: binding, verifier: verifier, nonce: nonce, expires: now.Add(LoginTimeout)}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), binding, nil
+}
+
+// Exchange completes the login started with state in the browser holding binding,
+// redeeming the authorization code for an ID token and returning its verified claims
+func (p *Provider) Exchange(ctx context.Context, state, binding, code string) (*Claims, error) {
+	p.mu.Lock()
+	login, ok := p.pending[state]
+	// A callback from another browser leaves the login to the one that started it
+	if ok && subtle.ConstantTimeCompare([]byte(login.binding), []byte(binding)) != 1 {
+		p.mu.Unlock()
+		return nil, ErrInvalidState
+	}
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, ErrInvalidState
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", login.verifier)
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("redeeming authorization code: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	return p.verify(ctx, d, token.IDToken, login.nonce)
+}
+
+// getDiscovery returns the provider metadata, fetching it the first time
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	d = &discovery{}
+	if err := p.do(req, d); err != nil {
+		return nil, fmt.Errorf("fetching provider configuration: %w", err)
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("provider configuration is for issuer %q", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("provider configuration is incomplete")
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// do sends a request to the provider and decodes its JSON response into v
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider responded %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// randomString returns 32 random bytes encoded for use in URLs
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "10/1m", want: Limit{Rate: 10.0 / 60, Burst: 10}},
+		{in: "100/10s", want: Limit{Rate: 10, Burst: 100}},
+		{in: "1/500ms", want: Limit{Rate: 2, Burst: 1}},
+		{in: "10", wantErr: true},
+		{in: "ten/1m", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "10/", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/-1m", wantErr: true},
+		{in: "10/minute", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseLimit(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLimit(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// clock is a settable time source for a Limiter
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestLimiter returns a limiter reading the time from a clock the test controls
+func newTestLimiter(limit Limit) (*Limiter, *clock) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	l := New(limit)
+	l.now = func() time.Time { return c.now }
+	l.lastSweep = c.now
+	return l, c
+}
+
+func TestAllow(t *testing.T) {
+	// 2 requests at once, then one more every 500ms
+	type step struct {
+		advance  time.Duration
+		key      string
+		want     bool
+		wantWait time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "burst then empty",
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "partial refill",
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{advance: 200 * time.Millisecond, key: "a", want: false, wantWait: 300 * time.Millisecond},
+				{advance: 300 * time.Millisecond, key: "a", want: true},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill stops at the burst",
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{advance: time.Hour, key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "refused requests don't take tokens",
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, key: "a", want: true},
+			},
+		},
+		{
+			name: "keys have their own bucket",
+			steps: []step{
+				{key: "a", want: true},
+				{key: "a", want: true},
+				{key: "a", want: false, wantWait: 500 * time.Millisecond},
+				{key: "b", want: true},
+				{key: "b", want: true},
+				{key: "b", want: false, wantWait: 500 * time.Millisecond},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, c := newTestLimiter(Limit{Rate: 2, Burst: 2})
+			for i, s := range tt.steps {
+				c.advance(s.advance)
+				ok, wait := l.Allow(s.key)
+				if ok != s.want || wait != s.wantWait {
+					t.Errorf("step %d: Allow(%q) = %v, %s, want %v, %s", i, s.key, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l, c := newTestLimiter(Limit{Rate: 0.1, Burst: 10})
+
+	// "idle" refills completely before the sweep, "busy" is still short of tokens
+	for i := 0; i < 10; i++ {
+		l.Allow("busy")
+	}
+	l.Allow("idle")
+	c.advance(sweepInterval - time.Second)
+	l.Allow("busy")
+	c.advance(time.Second)
+	l.Allow("other")
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("the refilled bucket was not dropped")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("a bucket still refilling was dropped")
+	}
+	if !l.lastSweep.Equal(c.now) {
+		t.Errorf("last sweep at %s, want %s", l.lastSweep, c.now)
+	}
+
+	// No sweep before the interval has passed again
+	l.Allow("idle")
+	c.advance(sweepInterval - time.Second)
+	l.Allow("other")
+	if _, ok := l.buckets["idle"]; !ok {
+		t.Error("buckets were swept before the interval")
+	}
+}
+
+func TestSweptBucketStartsFull(t *testing.T) {
+	l, c := newTestLimiter(Limit{Rate: 1, Burst: 3})
+	for i := 0; i < 3; i++ {
+		l.Allow("a")
+	}
+	c.advance(sweepInterval)
+	l.Allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("the refilled bucket was not dropped")
+	}
+
+	// A dropped bucket behaves as it would have if it had been kept
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Errorf("request %d refused after the sweep", i)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("request allowed past the burst after the sweep")
+	}
+}
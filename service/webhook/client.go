@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a webhook or bot endpoint resolves to an address
+// of the server's own network
+var ErrPrivateAddress = errors.New("private address")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPrivateAddress reports whether an address is loopback, private, link-local (which
+// includes cloud metadata services at 169.254.169.254), multicast or unspecified
+func IsPrivateAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// NewClient returns a client for calling the URLs users register. It doesn't follow
+// redirects or use proxies and, unless allowPrivate is set for local development,
+// refuses to connect to private addresses. The check runs on the resolved address
+// of every connection, so a host name can't be pointed to the server's network after
+// it was registered. A zero timeout leaves it to the request context.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !allowPrivate {
+		dialer.Control = denyPrivateAddress
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect is reported as a failure rather than followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// denyPrivateAddress is a net.Dialer Control function rejecting private addresses
+func denyPrivateAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if IsPrivateAddress(addrPort.Addr()) {
+		return fmt.Errorf("connecting to %s: %w", addrPort.Addr(), ErrPrivateAddress)
+	}
+	return nil
+}
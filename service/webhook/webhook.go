@@ -0,0 +1,378 @@
+/*
+Package webhook delivers the events of a conversation to the URLs registered for it.
+Deliveries are stored in the database before being sent, and failed attempts are
+retried with exponential backoff, so events survive restarts and receiver outages.
+*/
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Events sent to webhooks
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageUpdated  = "message.updated"
+	EventMessageDeleted  = "message.deleted"
+	EventReactionAdded   = "reaction.added"
+	EventReactionRemoved = "reaction.removed"
+	EventMemberAdded     = "member.added"
+	EventMemberRemoved   = "member.removed"
+)
+
+// Events lists every event a webhook can subscribe to
+var Events = []string{
+	EventMessageCreated, EventMessageUpdated, EventMessageDeleted,
+	EventReactionAdded, EventReactionRemoved,
+	EventMemberAdded, EventMemberRemoved,
+}
+
+// Headers of a delivery request
+const (
+	HeaderEvent     = "X-WASAText-Event"
+	HeaderDelivery  = "X-WASAText-Delivery"
+	HeaderTimestamp = "X-WASAText-Timestamp"
+	HeaderSignature = "X-WASAText-Signature"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is sent before giving up
+	MaxAttempts = 8
+
+	// firstRetryDelay doubles after every failed attempt, up to maxRetryDelay
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = time.Hour
+
+	// pollInterval is how often due retries are looked for when nothing is published
+	pollInterval = 5 * time.Second
+
+	// batchSize bounds the deliveries sent in one pass
+	batchSize = 50
+
+	// workers is how many deliveries are sent at once, so that a slow receiver
+	// doesn't hold up the others
+	workers = 8
+
+	dialTimeout    = 10 * time.Second
+	requestTimeout = 10 * time.Second
+
+	// Delivered and failed deliveries are deleted after deliveryRetention, checked
+	// every pruneInterval
+	deliveryRetention = 7 * 24 * time.Hour
+	pruneInterval     = time.Hour
+
+	// maxErrorLength bounds the error stored in the delivery log
+	maxErrorLength = 200
+
+	secretBytes = 32
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID             string      `json:"id"`
+	Event          string      `json:"event"`
+	ConversationID string      `json:"conversationId"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	Data           interface{} `json:"data"`
+}
+
+// NewSecret returns a random signing secret for a new webhook
+func NewSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value of a body sent at timestamp. Receivers
+// compute the HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret and compare.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryDelay returns how long to wait after the given number of failed attempts
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// Dispatcher queues events for the webhooks of a conversation and sends them in the background
+type Dispatcher struct {
+	db     database.AppDatabase
+	logger logrus.FieldLogger
+	client *http.Client
+
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDispatcher starts a dispatcher sending the deliveries queued in db. Webhooks on
+// private addresses are only reached with allowPrivate, see NewClient.
+func NewDispatcher(db database.AppDatabase, logger logrus.FieldLogger, allowPrivate bool) *Dispatcher {
+	d := &Dispatcher{
+		db:     db,
+		logger: logger,
+		client: NewClient(requestTimeout, allowPrivate),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Publish queues an event for every webhook of the conversation subscribed to it
+func (d *Dispatcher) Publish(conversationID, event string, data interface{}) error {
+	webhooks, err := d.db.GetWebhooks(conversationID)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []database.WebhookDelivery
+	var body []byte
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, event) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(Payload{
+				ID:             uuid.NewString(),
+				Event:          event,
+				ConversationID: conversationID,
+				CreatedAt:      time.Now().UTC(),
+				Data:           data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		deliveries = append(deliveries, database.WebhookDelivery{
+			ID:        uuid.NewString(),
+			WebhookID: webhook.ID,
+			Event:     event,
+			Payload:   string(body),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := d.db.CreateWebhookDeliveries(deliveries); err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// Wake makes the dispatcher send the due deliveries now, e.g. after a redelivery is queued
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the dispatcher once the deliveries in progress are done. Pending
+// deliveries stay queued for the next start.
+func (d *Dispatcher) Close() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	<-d.done
+	return nil
+}
+
+func subscribed(webhook database.Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	d.prune()
+	for {
+		d.sendDue()
+
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		case <-pruneTicker.C:
+			d.prune()
+		}
+	}
+}
+
+// prune deletes the deliveries that were delivered or given up on before the retention period
+func (d *Dispatcher) prune() {
+	before := time.Now().Add(-deliveryRetention).UTC().Format(time.RFC3339)
+	if err := d.db.DeleteWebhookDeliveries(before); err != nil {
+		d.logger.WithError(err).Error("can't prune webhook deliveries")
+	}
+}
+
+// sendDue sends the due deliveries, batch after batch, until none is left or the
+// dispatcher is closed. The deliveries of a batch are shared among the workers, and
+// the next batch is fetched once they are all done, so none is sent twice at once.
+func (d *Dispatcher) sendDue() {
+	for {
+		deliveries, err := d.db.GetDueWebhookDeliveries(batchSize)
+		if err != nil {
+			d.logger.WithError(err).Error("can't get due webhook deliveries")
+			return
+		}
+
+		queue := make(chan database.WebhookDelivery)
+		var wg sync.WaitGroup
+		for i := 0; i < workers && i < len(deliveries); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for delivery := range queue {
+					d.attempt(delivery)
+				}
+			}()
+		}
+
+		stopped := false
+	send:
+		for _, delivery := range deliveries {
+			select {
+			case <-d.stop:
+				stopped = true
+				break send
+			case queue <- delivery:
+			}
+		}
+		close(queue)
+		wg.Wait()
+
+		if stopped || len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *Dispatcher) attempt(delivery database.WebhookDelivery) {
+	logger := d.logger.WithField("delivery", delivery.ID)
+
+	webhook, err := d.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		logger.WithError(err).Error("can't get webhook")
+		return
+	}
+	if webhook == nil {
+		// Deleted while the delivery was queued
+		err = d.db.RecordWebhookAttempt(delivery.ID, 0, "webhook deleted", database.DeliveryFailed, "")
+		if err != nil {
+			logger.WithError(err).Error("can't record webhook attempt")
+		}
+		return
+	}
+
+	// The creator may have left, been demoted or lost their bot since the delivery was queued
+	manager, err := d.db.IsWebhookManager(webhook.ConversationID, webhook.CreatedBy)
+	if err != nil {
+		logger.WithError(err).Error("can't check webhook creator")
+		return
+	}
+	if !manager {
+		err = d.db.RecordWebhookAttempt(delivery.ID, 0, "webhook creator can no longer manage it", database.DeliveryFailed, "")
+		if err != nil {
+			logger.WithError(err).Error("can't record webhook attempt")
+		}
+		return
+	}
+
+	statusCode, sendErr := d.send(webhook, delivery)
+
+	status, next := database.DeliveryDelivered, ""
+	var errMsg string
+	if sendErr != nil {
+		errMsg = sendErr.Error()
+		if len(errMsg) > maxErrorLength {
+			errMsg = errMsg[:maxErrorLength]
+		}
+		status = database.DeliveryFailed
+		if attempts := delivery.Attempts + 1; attempts < MaxAttempts {
+			status = database.DeliveryPending
+			next = time.Now().Add(RetryDelay(attempts)).UTC().Format(time.RFC3339)
+		}
+		logger.WithError(sendErr).Debug("webhook delivery failed")
+	}
+
+	err = d.db.RecordWebhookAttempt(delivery.ID, statusCode, errMsg, status, next)
+	if err != nil {
+		logger.WithError(err).Error("can't record webhook attempt")
+	}
+}
+
+// send posts a delivery to its webhook and returns the response status code
+func (d *Dispatcher) send(webhook *database.Webhook, delivery database.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WASAText-Webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
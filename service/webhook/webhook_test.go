@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sapienzaapps/wasatext/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			// echo -n '1700000000.{"id":"1"}' | openssl dgst -sha256 -hmac secret
+			name:      "known value",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      `{"id":"1"}`,
+			want:      "sha256=086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54",
+		},
+		{
+			// echo -n '1700000000.' | openssl dgst -sha256 -hmac secret
+			name:      "empty body",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      "",
+			want:      "sha256=4bc5f74d868b97888288889c5d9d65df02526f94c1592a79fdf4fe8b26e311e5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignCoversEveryInput(t *testing.T) {
+	base := Sign("secret", "1700000000", []byte("body"))
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+	}{
+		{"other secret", "secret2", "1700000000", "body"},
+		{"other timestamp", "secret", "1700000001", "body"},
+		{"other body", "secret", "1700000000", "body2"},
+		// The separator keeps the timestamp and the body apart
+		{"digit moved from the timestamp to the body", "secret", "170000000", "0body"},
+	}
+	for _, tt := range tests {
+		if Sign(tt.secret, tt.timestamp, []byte(tt.body)) == base {
+			t.Errorf("%s: same signature", tt.name)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// attemptDB holds a single webhook and records the outcome of delivery attempts
+type attemptDB struct {
+	database.AppDatabase
+	webhook *database.Webhook
+	manager bool
+
+	status string
+	errMsg string
+}
+
+func (db *attemptDB) GetWebhook(string) (*database.Webhook, error) {
+	return db.webhook, nil
+}
+
+func (db *attemptDB) IsWebhookManager(conversationID, userID string) (bool, error) {
+	return db.manager && conversationID == db.webhook.ConversationID && userID == db.webhook.CreatedBy, nil
+}
+
+func (db *attemptDB) RecordWebhookAttempt(_ string, _ int, attemptErr string, status, _ string) error {
+	db.status, db.errMsg = status, attemptErr
+	return nil
+}
+
+func TestAttempt(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HeaderSignature) != Sign("secret", r.Header.Get(HeaderTimestamp), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		manager      bool
+		wantStatus   string
+		wantReceived int32
+	}{
+		{"creator still manages the conversation", true, database.DeliveryDelivered, 1},
+		{"creator no longer manages the conversation", false, database.DeliveryFailed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received.Store(0)
+			db := &attemptDB{
+				webhook: &database.Webhook{ID: "hook", ConversationID: "group", CreatedBy: "user", URL: server.URL, Secret: "secret"},
+				manager: tt.manager,
+			}
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			d := &Dispatcher{db: db, logger: logger, client: NewClient(time.Second, true)}
+
+			d.attempt(database.WebhookDelivery{ID: "delivery", WebhookID: "hook", Event: EventMessageCreated, Payload: "{}"})
+
+			if db.status != tt.wantStatus {
+				t.Errorf("status = %s (%s), want %s", db.status, db.errMsg, tt.wantStatus)
+			}
+			if got := received.Load(); got != tt.wantReceived {
+				t.Errorf("received %d requests, want %d", got, tt.wantReceived)
+			}
+		})
+	}
+}
@@ -26,7 +26,8 @@ instance.interceptors.request.use(
 instance.interceptors.response.use(
     (response) => response,
     (error) => {
-        if (error.response && error.response.status === 401) {
+        // A 401 from login is a wrong password, not an expired session
+        if (error.response && error.response.status === 401 && error.config.url !== '/session') {
             localStorage.removeItem('wasatext_token')
             localStorage.removeItem('wasatext_user_id')
             window.location.href = '/'